@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	accessTokenTTL  = 30 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+	// The refresh cookie only travels to the refresh endpoint
+	refreshCookiePath = "/api/refresh-token"
+)
+
+// issueRefreshToken stores a new refresh token of familyID and returns it.
+func issueRefreshToken(ctx context.Context, q dbConn, username, familyID string) (string, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = q.Exec(ctx,
+		`INSERT INTO refresh_tokens (token_hash, family_id, username, expires_at)
+		VALUES ($1, $2, $3, $4)`,
+		tokenHash, familyID, username, time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// revokeRefreshFamily ends every token of familyID.
+func revokeRefreshFamily(ctx context.Context, q dbConn, familyID string) error {
+	_, err := q.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL`, familyID)
+	return err
+}
+
+func setAuthCookies(c *gin.Context, accessToken, refreshToken string) {
+	c.SetCookie("access_token", accessToken, int(accessTokenTTL.Seconds()), "/", "", false, true)
+	c.SetCookie("refresh_token", refreshToken, int(refreshTokenTTL.Seconds()), refreshCookiePath, "", false, true)
+}
+
+func clearAuthCookies(c *gin.Context) {
+	c.SetCookie("access_token", "", -1, "/", "", true, true)
+	c.SetCookie("refresh_token", "", -1, refreshCookiePath, "", true, true)
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// testUser creates an account with role that is removed, with its sessions,
+// when the test ends.
+func testUser(t *testing.T, role string) string {
+	t.Helper()
+	ctx := context.Background()
+	username := "test-" + uuid.New().String()[:8]
+	_, err := db.Exec(ctx,
+		`INSERT INTO users (username, email, password, role) VALUES ($1, $2, 'x', $3)`,
+		username, username+"@example.com", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(ctx, `DELETE FROM refresh_tokens WHERE username = $1`, username)
+		db.Exec(ctx, `DELETE FROM sessions WHERE username = $1`, username)
+		db.Exec(ctx, `DELETE FROM users WHERE username = $1`, username)
+	})
+	return username
+}
+
+// testSessionOf opens a session for username and returns its id.
+func testSessionOf(t *testing.T, username string) string {
+	t.Helper()
+	sessionID := uuid.New().String()
+	_, err := db.Exec(context.Background(),
+		`INSERT INTO sessions (id, username, expires_at) VALUES ($1, $2, $3)`,
+		sessionID, username, time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessionID
+}
+
+// testSession creates a user with one session and returns the session id
+// and its refresh token. Everything is removed when the test ends.
+func testSession(t *testing.T) (string, string) {
+	t.Helper()
+	username := testUser(t, RoleEditor)
+	sessionID := testSessionOf(t, username)
+	token, err := issueRefreshToken(context.Background(), db, username, sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessionID, token
+}
+
+// refresh calls the refresh endpoint with token and returns the response.
+func refresh(token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/refresh-token", nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: token})
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) string {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	needDB(t)
+	sessionID, token := testSession(t)
+
+	w := refresh(token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: got %d %s", w.Code, w.Body)
+	}
+	next := responseCookie(w, "refresh_token")
+	if next == "" || next == token {
+		t.Fatalf("refresh token not rotated: %q", next)
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(responseCookie(w, "access_token"), claims, func(*jwt.Token) (interface{}, error) {
+		return accessTokenKey, nil
+	})
+	if err != nil {
+		t.Fatalf("access token: %v", err)
+	}
+	if claims.SessionID != sessionID || claims.Role != RoleEditor {
+		t.Errorf("access token for session %q role %q, want %q %q", claims.SessionID, claims.Role, sessionID, RoleEditor)
+	}
+
+	var used bool
+	err = db.QueryRow(context.Background(),
+		`SELECT used_at IS NOT NULL FROM refresh_tokens WHERE token_hash = $1`, hashToken(token)).Scan(&used)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !used {
+		t.Error("presented token is not marked used")
+	}
+
+	// The new token continues the session
+	if w := refresh(next); w.Code != http.StatusOK {
+		t.Errorf("refresh with rotated token: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	needDB(t)
+	sessionID, token := testSession(t)
+
+	w := refresh(token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: got %d %s", w.Code, w.Body)
+	}
+	next := responseCookie(w, "refresh_token")
+
+	// The spent token shows up again, as if it had been stolen
+	if w := refresh(token); w.Code != http.StatusUnauthorized {
+		t.Fatalf("reuse: got %d %s, want 401", w.Code, w.Body)
+	}
+	if w := refresh(next); w.Code != http.StatusUnauthorized {
+		t.Errorf("latest token after reuse: got %d, want 401", w.Code)
+	}
+
+	var live int
+	err := db.QueryRow(context.Background(),
+		`SELECT count(*) FROM refresh_tokens WHERE family_id = $1 AND revoked_at IS NULL`, sessionID).Scan(&live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live != 0 {
+		t.Errorf("%d tokens of the family still live", live)
+	}
+	var revoked bool
+	err = db.QueryRow(context.Background(),
+		`SELECT revoked_at IS NOT NULL FROM sessions WHERE id = $1`, sessionID).Scan(&revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Error("session not revoked")
+	}
+}
+
+func TestRefreshExpiredToken(t *testing.T) {
+	needDB(t)
+	sessionID, token := testSession(t)
+	_, err := db.Exec(context.Background(),
+		`UPDATE refresh_tokens SET expires_at = now() - interval '1 minute' WHERE token_hash = $1`, hashToken(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := refresh(token)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expired token: got %d %s, want 401", w.Code, w.Body)
+	}
+	if responseCookie(w, "access_token") != "" {
+		t.Error("access token issued for an expired refresh token")
+	}
+
+	// Expiry alone is no sign of theft, the session stays
+	var revoked bool
+	err = db.QueryRow(context.Background(),
+		`SELECT revoked_at IS NOT NULL FROM sessions WHERE id = $1`, sessionID).Scan(&revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Error("session revoked for an expired token")
+	}
+}
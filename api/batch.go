@@ -0,0 +1,165 @@
+package api
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxBatchFiles caps the number of image parts in one batch upload.
+	maxBatchFiles = 50
+	// batchConcurrency is how many files of a batch are processed at once.
+	// Each one holds its decoded image in memory while it is processed.
+	batchConcurrency = 4
+)
+
+// Uploads many files in one request. The form carries any number of `image`
+// parts; `name`, `description` and `category_id` may be repeated in the same
+// order to give each file its own values, and a single `category_id` applies
+// to all of them. Every file is stored on its own, so the response reports
+// each one instead of failing the whole batch. Under the reject policy a
+// file sent twice is stored once, the later copies answer 409 with the index
+// of the first.
+func uploadImageBatch(c *gin.Context) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
+		return
+	}
+	files := form.File["image"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Image file is required"})
+		return
+	}
+	if len(files) > maxBatchFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d files per batch", maxBatchFiles)})
+		return
+	}
+
+	names := form.Value["name"]
+	descriptions := form.Value["description"]
+	categories := form.Value["category_id"]
+	allow := allowDuplicate(c)
+
+	results := make([]gin.H, len(files))
+	copies := map[int]int{}
+	if !allow && duplicatePolicy() == "reject" {
+		copies = batchCopies(files)
+	}
+
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, fh := range files {
+		if first, ok := copies[i]; ok {
+			results[i] = gin.H{
+				"index": i, "filename": fh.Filename, "status": http.StatusConflict,
+				"error": "Duplicate image", "match": "exact", "duplicate_of_index": first,
+			}
+			continue
+		}
+
+		in := newImage{
+			Name:           formValueAt(names, i, strings.TrimSuffix(fh.Filename, filepath.Ext(fh.Filename))),
+			Description:    formValueAt(descriptions, i, ""),
+			CategoryID:     formValueAt(categories, i, ""),
+			AllowDuplicate: allow,
+		}
+		if len(categories) == 1 {
+			in.CategoryID = categories[0]
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := gin.H{"index": i, "filename": fh.Filename}
+			results[i] = result
+			if in.CategoryID == "" {
+				result["status"] = http.StatusBadRequest
+				result["error"] = "category_id is required"
+				return
+			}
+
+			file, err := fh.Open()
+			if err != nil {
+				result["status"] = http.StatusBadRequest
+				result["error"] = "Failed to read upload"
+				return
+			}
+			defer file.Close()
+
+			created, fail := createImage(c.Request.Context(), file, in)
+			if fail != nil {
+				result["status"] = fail.Status
+				for k, v := range fail.Body {
+					result[k] = v
+				}
+				return
+			}
+			result["status"] = http.StatusOK
+			for k, v := range created {
+				result[k] = v
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, r := range results {
+		if r["status"] == http.StatusOK {
+			succeeded++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+		"results":   results,
+	})
+}
+
+// batchCopies finds parts with the same bytes as an earlier part of the
+// batch and maps their index to the index of the first one. Those are never
+// processed, so copies in one request can't slip past each other.
+func batchCopies(files []*multipart.FileHeader) map[int]int {
+	copies := map[int]int{}
+	seen := map[string]int{}
+	for i, fh := range files {
+		file, err := fh.Open()
+		if err != nil {
+			continue
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			continue
+		}
+		sum := string(h.Sum(nil))
+		if first, ok := seen[sum]; ok {
+			copies[i] = first
+		} else {
+			seen[sum] = i
+		}
+	}
+	return copies
+}
+
+// formValueAt returns values[i], or def when the field wasn't repeated that
+// often.
+func formValueAt(values []string, i int, def string) string {
+	if i < len(values) {
+		return values[i]
+	}
+	return def
+}
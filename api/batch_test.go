@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestUploadImageBatchIdenticalParts(t *testing.T) {
+	needDB(t)
+	t.Setenv("DIMAS_DUPLICATE_POLICY", "reject")
+	category := testCategory(t)
+
+	same, other := testPNG(t, 1), testPNG(t, 200)
+	req := multipartRequest(t, "/api/imgupl/batch",
+		map[string]string{"category_id": strconv.Itoa(category)}, same, same, other)
+	w := call(uploadImageBatch, req, nil, "tester", RoleEditor)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Succeeded int `json:"succeeded"`
+		Results   []struct {
+			Status           int  `json:"status"`
+			DuplicateOfIndex *int `json:"duplicate_of_index"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 3 {
+		t.Fatalf("%d results, want 3", len(resp.Results))
+	}
+	if resp.Results[0].Status != http.StatusOK || resp.Results[2].Status != http.StatusOK {
+		t.Errorf("distinct files: statuses %d and %d, want 200", resp.Results[0].Status, resp.Results[2].Status)
+	}
+	second := resp.Results[1]
+	if second.Status != http.StatusConflict || second.DuplicateOfIndex == nil || *second.DuplicateOfIndex != 0 {
+		t.Errorf("second copy: status %d duplicate of %v, want 409 of 0", second.Status, second.DuplicateOfIndex)
+	}
+
+	var stored int
+	db.QueryRow(req.Context(), `SELECT count(*) FROM images WHERE category_id = $1`, category).Scan(&stored)
+	if stored != 2 {
+		t.Errorf("%d images stored, want 2", stored)
+	}
+}
+
+func TestBatchCopies(t *testing.T) {
+	a, b := testPNG(t, 1), testPNG(t, 2)
+	req := multipartRequest(t, "/", nil, a, b, a, b, a)
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	got := batchCopies(req.MultipartForm.File["image"])
+	want := map[int]int{2: 0, 3: 1, 4: 0}
+	if len(got) != len(want) {
+		t.Fatalf("copies %v, want %v", got, want)
+	}
+	for i, first := range want {
+		if got[i] != first {
+			t.Errorf("copies %v, want %v", got, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgconn"
+)
+
+// Payload for creating or replacing a carousel slide. Side slots and the
+// category are optional; without a category the slide shows the category of
+// its main image.
+type carouselSlideInput struct {
+	MainImageID  int    `json:"main_image_id" binding:"required"`
+	LeftImageID  *int   `json:"left_image_id"`
+	RightImageID *int   `json:"right_image_id"`
+	CategoryID   *int   `json:"category_id"`
+	Description  string `json:"description"`
+	AltText      string `json:"alt_text"`
+}
+
+// isForeignKeyViolation reports whether err comes from a missing referenced row.
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+// slotURL returns "" for an empty side slot.
+func slotURL(urls map[int]string, id *int) string {
+	if id == nil {
+		return ""
+	}
+	return urls[*id]
+}
+
+// Public list of slides in display order, shaped like CarouselData in the frontend
+func getCarousel(c *gin.Context) {
+	rows, err := db.Query(context.Background(),
+		`SELECT
+			s.id,
+			s.main_image_id,
+			s.left_image_id,
+			s.right_image_id,
+			COALESCE(sc.name, mc.name, '') AS category,
+			s.description,
+			s.alt_text,
+			s.position
+		FROM carousel_slides s
+		JOIN images m ON m.id = s.main_image_id AND m.status = 'active' AND m.deleted_at IS NULL
+		LEFT JOIN categories sc ON sc.id = s.category_id
+		LEFT JOIN categories mc ON mc.id = m.category_id
+		ORDER BY s.position, s.id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer rows.Close()
+
+	slides := []gin.H{}
+	var ids []int
+	for rows.Next() {
+		var (
+			id, mainID, position    int
+			leftID, rightID         *int
+			category, desc, altText string
+		)
+		if err := rows.Scan(&id, &mainID, &leftID, &rightID, &category, &desc, &altText, &position); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse data"})
+			return
+		}
+
+		slides = append(slides, gin.H{
+			"id":             id,
+			"category":       category,
+			"description":    desc,
+			"alt_text":       altText,
+			"position":       position,
+			"main_image_id":  mainID,
+			"left_image_id":  leftID,
+			"right_image_id": rightID,
+		})
+		ids = append(ids, mainID)
+		for _, side := range []*int{leftID, rightID} {
+			if side != nil {
+				ids = append(ids, *side)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+
+	// The carousel is public, so it gets watermarked copies where they apply
+	urls, err := publicImageURLs(c.Request.Context(), ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "URL generation failed"})
+		return
+	}
+	// A main image without a watermarked copy yet is hidden with its slide;
+	// a side image is just left empty
+	shown := []gin.H{}
+	for _, slide := range slides {
+		mainURL, ok := urls[slide["main_image_id"].(int)]
+		if !ok {
+			continue
+		}
+		slide["main_url"] = mainURL
+		slide["left_url"] = slotURL(urls, slide["left_image_id"].(*int))
+		slide["right_url"] = slotURL(urls, slide["right_image_id"].(*int))
+		shown = append(shown, slide)
+	}
+
+	c.JSON(http.StatusOK, shown)
+}
+
+func addCarouselSlide(c *gin.Context) {
+	var input carouselSlideInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	// New slides go to the end of the carousel
+	var slideID int
+	err := db.QueryRow(context.Background(),
+		`INSERT INTO carousel_slides
+			(main_image_id, left_image_id, right_image_id, category_id, description, alt_text, position)
+		VALUES ($1, $2, $3, $4, $5, $6,
+			(SELECT COALESCE(MAX(position) + 1, 0) FROM carousel_slides))
+		RETURNING id`,
+		input.MainImageID, input.LeftImageID, input.RightImageID, input.CategoryID,
+		input.Description, input.AltText,
+	).Scan(&slideID)
+
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Referenced image or category does not exist"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add slide"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": slideID, "message": "Slide added successfully"})
+}
+
+func updateCarouselSlide(c *gin.Context) {
+	slideID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slide ID"})
+		return
+	}
+
+	var input carouselSlideInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	result, err := db.Exec(context.Background(),
+		`UPDATE carousel_slides SET
+			main_image_id = $1,
+			left_image_id = $2,
+			right_image_id = $3,
+			category_id = $4,
+			description = $5,
+			alt_text = $6
+		WHERE id = $7`,
+		input.MainImageID, input.LeftImageID, input.RightImageID, input.CategoryID,
+		input.Description, input.AltText, slideID,
+	)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Referenced image or category does not exist"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		}
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Slide not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Slide updated successfully"})
+}
+
+func deleteCarouselSlide(c *gin.Context) {
+	slideID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slide ID"})
+		return
+	}
+
+	result, err := db.Exec(context.Background(), "DELETE FROM carousel_slides WHERE id = $1", slideID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete slide"})
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Slide not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Slide deleted successfully"})
+}
+
+// Reorders slides; the body lists slide ids in their new display order.
+func reorderCarousel(c *gin.Context) {
+	var input struct {
+		IDs []int `json:"ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	for position, id := range input.IDs {
+		result, err := tx.Exec(context.Background(),
+			"UPDATE carousel_slides SET position = $1 WHERE id = $2", position, id,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Reorder failed"})
+			return
+		}
+		if result.RowsAffected() == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Slide not found", "id": id})
+			return
+		}
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Carousel reordered"})
+}
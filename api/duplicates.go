@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+
+	"golang-api/imaging"
+)
+
+// Duplicate handling is configured with DIMAS_DUPLICATE_POLICY ("reject",
+// the default, or "flag") and DIMAS_DUPLICATE_DISTANCE, the largest dHash
+// Hamming distance still treated as the same picture (default 5).
+const defaultDuplicateDistance = 5
+
+func duplicatePolicy() string {
+	if os.Getenv("DIMAS_DUPLICATE_POLICY") == "flag" {
+		return "flag"
+	}
+	return "reject"
+}
+
+func duplicateDistance() int {
+	if n, err := strconv.Atoi(os.Getenv("DIMAS_DUPLICATE_DISTANCE")); err == nil && n >= 0 {
+		return n
+	}
+	return defaultDuplicateDistance
+}
+
+// imageHashes are the content and perceptual hashes of a stored image.
+type imageHashes struct {
+	SHA256 string
+	PHash  uint64
+}
+
+func hashImage(clean *imaging.Sanitized) imageHashes {
+	sum := sha256.Sum256(clean.Data)
+	return imageHashes{SHA256: hex.EncodeToString(sum[:]), PHash: imaging.DHash(clean.Image)}
+}
+
+type duplicateMatch struct {
+	ID       int    `json:"id"`
+	Match    string `json:"match"`
+	Distance int    `json:"distance"`
+}
+
+// findDuplicate returns the closest existing image that is an exact copy or
+// within maxDistance of h, ignoring excludeID. It returns nil if none is.
+func findDuplicate(tx pgx.Tx, h imageHashes, maxDistance, excludeID int) (*duplicateMatch, error) {
+	var exactID int
+	err := tx.QueryRow(context.Background(),
+		`SELECT COALESCE(MIN(id), 0) FROM images
+		WHERE sha256 = $1 AND id <> $2 AND status = 'active' AND deleted_at IS NULL`, h.SHA256, excludeID,
+	).Scan(&exactID)
+	if err != nil {
+		return nil, err
+	}
+	if exactID != 0 {
+		return &duplicateMatch{ID: exactID, Match: "exact"}, nil
+	}
+
+	rows, err := tx.Query(context.Background(),
+		`SELECT id, phash FROM images
+		WHERE phash IS NOT NULL AND id <> $1 AND status = 'active' AND deleted_at IS NULL`, excludeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var best *duplicateMatch
+	for rows.Next() {
+		var (
+			id    int
+			phash int64
+		)
+		if err := rows.Scan(&id, &phash); err != nil {
+			return nil, err
+		}
+		d := imaging.HammingDistance(h.PHash, uint64(phash))
+		if d <= maxDistance && (best == nil || d < best.Distance) {
+			best = &duplicateMatch{ID: id, Match: "similar", Distance: d}
+		}
+	}
+	return best, rows.Err()
+}
+
+// screenDuplicate runs findDuplicate for an upload unless allow is set.
+// Under the reject policy a match comes back as a 409 failure carrying the
+// existing image id; under the flag policy it is returned so it can be passed
+// back to the client.
+//
+// tx is the transaction that will write the upload's hashes. It holds a lock
+// on the SHA-256 until it ends, so a concurrent upload of the same file waits
+// and then sees this one.
+func screenDuplicate(tx pgx.Tx, h imageHashes, excludeID int, allow bool) (*duplicateMatch, *uploadFailure) {
+	if allow {
+		return nil, nil
+	}
+
+	_, err := tx.Exec(context.Background(), `SELECT pg_advisory_xact_lock(hashtext('image-sha256:' || $1))`, h.SHA256)
+	if err != nil {
+		return nil, &uploadFailure{http.StatusInternalServerError, gin.H{"error": "Database error"}}
+	}
+	match, err := findDuplicate(tx, h, duplicateDistance(), excludeID)
+	if err != nil {
+		return nil, &uploadFailure{http.StatusInternalServerError, gin.H{"error": "Database error"}}
+	}
+	if match != nil && duplicatePolicy() == "reject" {
+		return nil, &uploadFailure{http.StatusConflict, gin.H{
+			"error":       "Duplicate image",
+			"existing_id": match.ID,
+			"match":       match.Match,
+			"distance":    match.Distance,
+		}}
+	}
+	return match, nil
+}
+
+// checkDuplicate is screenDuplicate for a single-upload request, honouring
+// allow_duplicate=true in the form or query. It returns ok=false once a
+// response has been written.
+func checkDuplicate(c *gin.Context, tx pgx.Tx, h imageHashes, excludeID int) (match *duplicateMatch, ok bool) {
+	match, fail := screenDuplicate(tx, h, excludeID, allowDuplicate(c))
+	if fail != nil {
+		c.JSON(fail.Status, fail.Body)
+		return nil, false
+	}
+	return match, true
+}
+
+func allowDuplicate(c *gin.Context) bool {
+	return c.PostForm("allow_duplicate") == "true" || c.Query("allow_duplicate") == "true"
+}
+
+// Lists groups of images that look like the same picture, for cleanup.
+// ?distance= overrides the configured threshold.
+func getDuplicateClusters(c *gin.Context) {
+	maxDistance := duplicateDistance()
+	if d, err := strconv.Atoi(c.Query("distance")); err == nil && d >= 0 && d <= 64 {
+		maxDistance = d
+	}
+
+	rows, err := db.Query(context.Background(),
+		`SELECT id, name, s3_key, sha256, phash FROM images
+		WHERE phash IS NOT NULL AND status = 'active' AND deleted_at IS NULL
+		ORDER BY id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer rows.Close()
+
+	type candidate struct {
+		ID     int    `json:"id"`
+		Name   string `json:"name"`
+		S3Key  string `json:"s3_key"`
+		sha256 string
+		phash  uint64
+	}
+	var images []candidate
+	for rows.Next() {
+		var (
+			img   candidate
+			sum   *string
+			phash int64
+		)
+		if err := rows.Scan(&img.ID, &img.Name, &img.S3Key, &sum, &phash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse data"})
+			return
+		}
+		if sum != nil {
+			img.sha256 = *sum
+		}
+		img.phash = uint64(phash)
+		images = append(images, img)
+	}
+
+	// Union-find over every pair within the threshold
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			if imaging.HammingDistance(images[i].phash, images[j].phash) <= maxDistance ||
+				(images[i].sha256 != "" && images[i].sha256 == images[j].sha256) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := map[int][]candidate{}
+	for i := range images {
+		root := find(i)
+		groups[root] = append(groups[root], images[i])
+	}
+
+	clusters := []gin.H{}
+	for _, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+		exact := true
+		for _, m := range members[1:] {
+			if m.sha256 == "" || m.sha256 != members[0].sha256 {
+				exact = false
+			}
+		}
+		clusters = append(clusters, gin.H{"exact": exact, "images": members})
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i]["images"].([]candidate)[0].ID < clusters[j]["images"].([]candidate)[0].ID
+	})
+
+	c.JSON(http.StatusOK, gin.H{"distance": maxDistance, "clusters": clusters})
+}
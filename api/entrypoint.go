@@ -5,11 +5,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"encoding/json"
 	"fmt"
-	"path/filepath"
 	"time"
 	"strings"
 	"strconv"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
@@ -17,26 +18,25 @@ import (
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/jackc/pgx/v4"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/google/uuid"
-
 	"github.com/golang-jwt/jwt/v5"
+
+	"golang-api/mail"
+	"golang-api/storage"
 )
 
 var (
 	app 		*gin.Engine
 	db        	*pgxpool.Pool
-	s3Client  	*s3.Client
+	store     	storage.Storage
+	presigned 	*storage.URLCache
+	mailer    	mail.Mailer
 	accessTokenKey = []byte(os.Getenv("DIMAS_JWT_ACCESS_TOKEN"))
-	refreshTokenKey = []byte(os.Getenv("DIMAS_JWT_REFRESH_TOKEN"))
 )
 
 type Claims struct {
-    Username string `json:"username"`
+    Username  string `json:"username"`
+    SessionID string `json:"sid"`
+    Role      string `json:"role"`
     jwt.RegisteredClaims
 }
 
@@ -61,10 +61,12 @@ func isValidUser(username, password string) bool {
     return bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password)) == nil
 }
 
-func GenerateAccessToken(username string) (string, error) {
-    expirationTime := time.Now().Add(30 * time.Minute)
+func GenerateAccessToken(username, sessionID, role string) (string, error) {
+    expirationTime := time.Now().Add(accessTokenTTL)
     claims := &Claims{
-        Username: username,
+        Username:  username,
+        SessionID: sessionID,
+        Role:      role,
         RegisteredClaims: jwt.RegisteredClaims{
             ExpiresAt: jwt.NewNumericDate(expirationTime),
         },
@@ -73,18 +75,6 @@ func GenerateAccessToken(username string) (string, error) {
     return token.SignedString(accessTokenKey)
 }
 
-func GenerateRefreshToken(username string) (string, error) {
-    expirationTime := time.Now().Add(7 * 24 * time.Hour)
-    claims := &Claims{
-        Username: username,
-        RegisteredClaims: jwt.RegisteredClaims{
-            ExpiresAt: jwt.NewNumericDate(expirationTime),
-        },
-    }
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    return token.SignedString(refreshTokenKey)
-}
-
 func MeHandler(c *gin.Context) {
     user, exists := c.Get("user") // Ambil dari JWT middleware
     if !exists {
@@ -109,7 +99,13 @@ func init() {
     app.SetTrustedProxies([]string{"https://marugo-porto.vercel.app/api"})
 	r := app.Group("/api")
 	myRouter(r)
+}
 
+var connectOnce sync.Once
+
+// connect sets up the database, storage and mailer the handlers use. Handler
+// runs it on the first request, so importing the package connects nothing.
+func connect() {
 	// Fetch DATABASE_URL from environment variables
 	databaseUrl := os.Getenv("STORAGE_DATABASE_URL")
 
@@ -137,22 +133,19 @@ func init() {
         log.Fatalf("Database ping failed: %v", err)
     }
 
-	// Load environment variables
-	var aws_err error
-	awsConfig, aws_err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("DIMAS_AWS_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			os.Getenv("DIMAS_AWS_ACCESS_KEY_ID"),
-			os.Getenv("DIMAS_AWS_SECRET_ACCESS_KEY"),
-			"",
-		)),
-	)
-	if aws_err != nil {
-		log.Fatal("AWS config error:", aws_err)
+	// Object storage backend (s3, local or memory), see storage.FromEnv
+	store, err = storage.FromEnv(context.Background())
+	if err != nil {
+		log.Fatal("Storage config error:", err)
 	}
+	// Presigned GET URLs are reused until 2 minutes before they expire
+	presigned = storage.NewURLCache(store, 2*time.Minute, 8)
 
-	// Initialize S3 client
-	s3Client = s3.NewFromConfig(awsConfig)
+	// Verification and password reset emails (smtp, file or log), see mail.FromEnv
+	mailer, err = mail.FromEnv()
+	if err != nil {
+		log.Fatal("Mail config error:", err)
+	}
 }
 
 // Ping route for health checks
@@ -181,17 +174,35 @@ func LoginUserHandler(c *gin.Context) {
         return
     }
 
-    if isValidUser(creds.Username, creds.Password) {
-        accessToken, _ := GenerateAccessToken(creds.Username)
-        refreshToken, _ := GenerateRefreshToken(creds.Username)
+    if !isValidUser(creds.Username, creds.Password) {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+        return
+    }
 
-        c.SetCookie("access_token", accessToken, 1800, "/", "", false, true)
-        c.SetCookie("refresh_token", refreshToken, 7*24*3600, "/refresh-token", "", false, true)
+    // Email harus dikonfirmasi dulu sebelum bisa login
+    verified, err := emailVerified(context.Background(), creds.Username)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+        return
+    }
+    if !verified {
+        c.JSON(http.StatusForbidden, gin.H{"error": "Email not verified", "email_unverified": true})
+        return
+    }
 
-        c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
-    } else {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+    // Token dan session lama yang sudah expired tidak perlu disimpan lagi
+    db.Exec(context.Background(),
+        `DELETE FROM refresh_tokens WHERE username = $1 AND expires_at < now()`, creds.Username)
+    db.Exec(context.Background(),
+        `DELETE FROM sessions WHERE username = $1 AND expires_at < now()`, creds.Username)
+
+    // Every login is a new session with its own refresh token family
+    if err := startSession(c, creds.Username); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+        return
     }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
 }
 
 // Create a new user
@@ -200,6 +211,7 @@ func createUserHandler(c *gin.Context) {
 		Username string `json:"username" binding:"required"`
         Email    string `json:"email" binding:"required,email"`
 		Password string `json:"password" binding:"required"`
+		InviteCode string `json:"invite_code"`
 	}
 
 	// Parse dan validasi body
@@ -213,9 +225,23 @@ func createUserHandler(c *gin.Context) {
 		return
 	}
 
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	// Sign-ups take turns, so two of them can't both see an empty table and
+	// become owner, or claim the same username
+	if _, err := tx.Exec(context.Background(), "SELECT pg_advisory_xact_lock(hashtext('create-user'))"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
 	// Cek apakah username sudah ada
 	var exists bool
-	err := db.QueryRow(context.Background(),
+	err = tx.QueryRow(context.Background(),
 		"SELECT EXISTS (SELECT 1 FROM users WHERE username = $1 or email = $2)",
 		req.Username, req.Email,
 	).Scan(&exists)
@@ -230,6 +256,45 @@ func createUserHandler(c *gin.Context) {
 		return
 	}
 
+	// Akun pertama selalu boleh dibuat dan menjadi owner; setelah itu
+	// tergantung registration mode
+	var hasUsers bool
+	err = tx.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM users)").Scan(&hasUsers)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	role := RoleOwner
+	if hasUsers {
+		mode, err := registrationMode(context.Background(), tx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		role = RoleViewer
+		switch {
+		case mode == RegistrationClosed:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Registration is closed"})
+			return
+		case req.InviteCode != "":
+			// The use is given back if the account isn't created after all
+			invited, ok, err := consumeInvite(context.Background(), tx, req.InviteCode)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if !ok {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired invite code"})
+				return
+			}
+			role = invited
+		case mode == RegistrationInvite:
+			c.JSON(http.StatusForbidden, gin.H{"error": "An invite code is required"})
+			return
+		}
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -238,17 +303,27 @@ func createUserHandler(c *gin.Context) {
 	}
 
 	// Simpan user ke database
-	_, err = db.Exec(context.Background(),
-		"INSERT INTO users (username, email, password) VALUES ($1, $2, $3)",
-		req.Username, req.Email, string(hashedPassword),
+	_, err = tx.Exec(context.Background(),
+		"INSERT INTO users (username, email, password, role) VALUES ($1, $2, $3, $4)",
+		req.Username, req.Email, string(hashedPassword), role,
 	)
 
+	if err == nil {
+		err = tx.Commit(context.Background())
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Gagal membuat akun"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "User berhasil dibuat"})
+	// Akun baru belum bisa login sebelum email dikonfirmasi
+	sent := true
+	if err := sendVerification(c.Request.Context(), req.Username, req.Email); err != nil {
+		log.Printf("send verification to %s: %v", req.Username, err)
+		sent = false
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "User berhasil dibuat", "role": role, "verification_sent": sent})
 }
 
 func AuthGinMiddleware() gin.HandlerFunc {
@@ -269,8 +344,21 @@ func AuthGinMiddleware() gin.HandlerFunc {
             return
         }
 
+        // Session bisa saja sudah di-revoke sebelum token expired
+        live, err := checkSession(context.Background(), claims.SessionID, claims.Username)
+        if err != nil {
+            c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+            return
+        }
+        if !live {
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+            return
+        }
+
         // Simpan ke context Gin
         c.Set("username", claims.Username)
+        c.Set("session_id", claims.SessionID)
+        c.Set("role", claims.Role)
         c.Next()
     }
 }
@@ -282,97 +370,136 @@ func RefreshTokenHandlerGin(c *gin.Context) {
         return
     }
 
-    claims := &Claims{}
-    token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
-        return refreshTokenKey, nil
-    })
+    tx, err := db.Begin(context.Background())
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+        return
+    }
+    defer tx.Rollback(context.Background())
 
-    if err != nil || !token.Valid {
+    var (
+        tokenID            int
+        familyID, username string
+        expiresAt          time.Time
+        usedAt, revokedAt  *time.Time
+    )
+    err = tx.QueryRow(context.Background(),
+        `SELECT id, family_id, username, expires_at, used_at, revoked_at
+        FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`, hashToken(cookie.Value),
+    ).Scan(&tokenID, &familyID, &username, &expiresAt, &usedAt, &revokedAt)
+    if err != nil {
+        if err == pgx.ErrNoRows {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+        } else {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+        }
+        return
+    }
+
+    // Token yang sudah dipakai muncul lagi: anggap dicuri, matikan seluruh family
+    // beserta session-nya
+    if usedAt != nil && revokedAt == nil {
+        err := revokeRefreshFamily(context.Background(), tx, familyID)
+        if err == nil {
+            _, err = tx.Exec(context.Background(),
+                `UPDATE sessions SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, familyID)
+        }
+        if err != nil || tx.Commit(context.Background()) != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+            return
+        }
+        log.Printf("refresh token reuse for %s, family %s revoked", username, familyID)
+        clearAuthCookies(c)
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected"})
+        return
+    }
+    if revokedAt != nil || usedAt != nil || expiresAt.Before(time.Now()) {
+        clearAuthCookies(c)
         c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
         return
     }
 
-    newAccessToken, _ := GenerateAccessToken(claims.Username)
+    // The family is the session; a revoked session can't be refreshed
+    tag, err := tx.Exec(context.Background(),
+        `UPDATE sessions SET expires_at = $1, last_seen_at = now()
+        WHERE id = $2 AND revoked_at IS NULL`, time.Now().Add(refreshTokenTTL), familyID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+        return
+    }
+    if tag.RowsAffected() == 0 {
+        clearAuthCookies(c)
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+        return
+    }
 
-    http.SetCookie(c.Writer, &http.Cookie{
-        Name:     "access_token",
-        Value:    newAccessToken,
-        HttpOnly: true,
-        Path:     "/",
-        MaxAge:   1800,
-    })
+    // Rotate: this token is spent, the next one continues the family
+    _, err = tx.Exec(context.Background(), `UPDATE refresh_tokens SET used_at = now() WHERE id = $1`, tokenID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+        return
+    }
+    refreshToken, err := issueRefreshToken(context.Background(), tx, username, familyID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+        return
+    }
+    // Role changes since the last refresh apply to the new token
+    role, err := userRole(context.Background(), tx, username)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+        return
+    }
+    accessToken, err := GenerateAccessToken(username, familyID, role)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+        return
+    }
+    if err := tx.Commit(context.Background()); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+        return
+    }
 
+    setAuthCookies(c, accessToken, refreshToken)
     c.JSON(http.StatusOK, gin.H{"message": "Access token refreshed"})
 }
 
 func LogoutHandlerGin(c *gin.Context) {
+    // Matikan session di server, token yang tercuri ikut tidak berlaku
+    if claims := sessionFromCookie(c); claims != nil {
+        if _, err := revokeSessions(context.Background(), claims.Username, `id = $2`, claims.SessionID); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+            return
+        }
+    }
+
     // Overwrite dengan expired cookies
-    c.SetCookie("access_token", "", -1, "/", "", true, true)
-    c.SetCookie("refresh_token", "", -1, "/refresh-token", "", true, true)
+    clearAuthCookies(c)
 
     c.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
 }
 
 func uploadImage(c *gin.Context) {
-    // Inisialisasi transaksi
-    tx, err := db.Begin(context.Background())
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
-        return
-    }
-    defer tx.Rollback(context.Background())
-
     // Proses upload file
-    file, header, _ := c.Request.FormFile("image")
-    defer file.Close()
-
-	// Validate file type
-	if !isValidImageType(header.Header.Get("Content-Type")) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image format. Only PNG/JPEG allowed"})
-		return
-	}
-
-    // Generate S3 key
-    ext := filepath.Ext(header.Filename)
-    objectKey := fmt.Sprintf("images/%s%s", uuid.New().String(), ext)
-
-    // Insert ke database DALAM TRANSAKSI
-    var imageID int
-    err = tx.QueryRow(context.Background(),
-        `INSERT INTO images (name, category_id, description, s3_key) 
-        VALUES ($1, $2, $3, $4) RETURNING id`,
-        c.PostForm("name"),
-        c.PostForm("category_id"),
-        c.PostForm("description"),
-        objectKey,
-    ).Scan(&imageID)
-
+    file, _, err := c.Request.FormFile("image")
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Image file is required"})
         return
     }
+    defer file.Close()
 
-    // Upload ke S3
-    _, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-        Bucket: aws.String("myport-crunchy-personal"),
-        Key:    aws.String(objectKey),
-        Body:   file,
-		ContentType: aws.String(header.Header.Get("Content-Type")),
-		ACL:         types.ObjectCannedACLPrivate,
+    result, fail := createImage(c.Request.Context(), file, newImage{
+        Name:           c.PostForm("name"),
+        CategoryID:     c.PostForm("category_id"),
+        Description:    c.PostForm("description"),
+        AllowDuplicate: allowDuplicate(c),
     })
-
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "S3 upload failed"})
+    if fail != nil {
+        c.JSON(fail.Status, fail.Body)
         return
     }
 
-    // Commit transaksi jika semua sukses
-    if err := tx.Commit(context.Background()); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
-        return
-    }
-
-    c.JSON(http.StatusOK, gin.H{"id": imageID, "s3_key": objectKey, "message": "Image uploaded"})
+    c.JSON(http.StatusOK, result)
 }
 
 func getOneImage(c *gin.Context) {
@@ -384,13 +511,22 @@ func getOneImage(c *gin.Context) {
 		CategoryID  int    `json:"category_id"`
 		Description string `json:"description"`
 		Url 		string `json:"url"`
+		Variants    gin.H  `json:"variants"`
+		Width       *int   `json:"width"`
+		Height      *int   `json:"height"`
+		BlurHash    *string `json:"blurhash"`
+		DominantColor *string `json:"dominant_color"`
+		Version     int    `json:"version"`
+		Metadata    json.RawMessage `json:"metadata"`
 	}
 
+	var metadata string
 	err := db.QueryRow(context.Background(),
-		`SELECT id, s3_key, name, category_id, description 
+		`SELECT id, s3_key, name, category_id, description, width, height, blurhash, dominant_color, version, metadata::text 
 		FROM images 
-		WHERE id = $1`, id,
-	).Scan(&image.ID, &image.S3Key, &image.Name, &image.CategoryID, &image.Description)
+		WHERE id = $1 AND status = 'active' AND deleted_at IS NULL`, id,
+	).Scan(&image.ID, &image.S3Key, &image.Name, &image.CategoryID, &image.Description,
+		&image.Width, &image.Height, &image.BlurHash, &image.DominantColor, &image.Version, &metadata)
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
@@ -398,17 +534,23 @@ func getOneImage(c *gin.Context) {
 	}
 
 	// Generate pre-signed URL
-	presignClient := s3.NewPresignClient(s3Client)
-	presignedUrl, err := presignClient.PresignGetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String("myport-crunchy-personal"),
-		Key:    aws.String(image.S3Key),
-	}, s3.WithPresignExpires(15*time.Minute))
-	
+	presignedUrl, err := presigned.PresignGet(c.Request.Context(), image.S3Key, 15*time.Minute)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "URL generation failed"})
 		return
 	}
-	image.Url = presignedUrl.URL
+	image.Url = presignedUrl
+	image.Metadata = json.RawMessage(metadata)
+
+	variants, err := loadVariantURLs(c.Request.Context(), []int{image.ID}, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "URL generation failed"})
+		return
+	}
+	image.Variants = variants[image.ID]
+	if image.Variants == nil {
+		image.Variants = gin.H{}
+	}
 
 	c.JSON(http.StatusOK, image)
 }
@@ -420,10 +562,14 @@ func getAllImages(c *gin.Context) {
 			i.s3_key, 
 			i.name, 
 			c.name as category_name, 
-			i.description 
+			i.description,
+			i.width,
+			i.height,
+			i.blurhash,
+			i.dominant_color
 		FROM images i
 		JOIN categories c ON i.category_id = c.id
-		WHERE i.s3_key LIKE 'images/%'`)
+		WHERE i.s3_key LIKE 'images/%' AND i.status = 'active' AND i.deleted_at IS NULL`)
 	
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
@@ -432,39 +578,55 @@ func getAllImages(c *gin.Context) {
 	defer rows.Close()
 
 	var images []gin.H
+	var ids []int
+	var keys []string
 
 	for rows.Next() {
 		var (
-			id           int
-			s3Key, name  string
-			categoryName string
-			description  string
+			id              int
+			s3Key, name     string
+			categoryName    string
+			description     string
+			width, height   *int
+			blurhash, color *string
 		)
 
-		if err := rows.Scan(&id, &s3Key, &name, &categoryName, &description); err != nil {
+		if err := rows.Scan(&id, &s3Key, &name, &categoryName, &description, &width, &height, &blurhash, &color); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse data"})
 			return
 		}
 
-		presignClient := s3.NewPresignClient(s3Client)
-		presignedUrl, err := presignClient.PresignGetObject(context.TODO(), &s3.GetObjectInput{
-			Bucket: aws.String("myport-crunchy-personal"),
-			Key:    aws.String(s3Key),
-		}, s3.WithPresignExpires(15*time.Minute))
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "URL generation failed for " + s3Key})
-			return
-		}
-
 		images = append(images, gin.H{
-			"id":          id,
-			"name":        name,
-			"s3_key":      s3Key,
-			"category":    categoryName,
-			"description": description,
-			"url":         presignedUrl.URL,
+			"id":             id,
+			"name":           name,
+			"s3_key":         s3Key,
+			"category":       categoryName,
+			"description":    description,
+			"width":          width,
+			"height":         height,
+			"blurhash":       blurhash,
+			"dominant_color": color,
 		})
+		ids = append(ids, id)
+		keys = append(keys, s3Key)
+	}
+
+	if err := signListing(c.Request.Context(), images, keys); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "URL generation failed"})
+		return
+	}
+
+	variants, err := loadVariantURLs(c.Request.Context(), ids, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Variant URL generation failed"})
+		return
+	}
+	for i, id := range ids {
+		if v, ok := variants[id]; ok {
+			images[i]["variants"] = v
+		} else {
+			images[i]["variants"] = gin.H{}
+		}
 	}
 
 	if len(images) == 0 {
@@ -475,22 +637,32 @@ func getAllImages(c *gin.Context) {
 	c.JSON(http.StatusOK, images)
 }
 
+// signListing sets the "url" of every image in a listing, keys[i] being the
+// original of images[i]. Everything is signed in one go, cached URLs are
+// reused.
+func signListing(ctx context.Context, images []gin.H, keys []string) error {
+	urls, err := presigned.PresignGetMany(ctx, keys, 15*time.Minute)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		images[i]["url"] = urls[key]
+	}
+	return nil
+}
+
 func deleteImage(c *gin.Context) {
-    // Mulai transaksi
-    tx, err := db.Begin(context.Background())
+    imageID, err := strconv.Atoi(c.Param("id"))
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
         return
     }
-    defer tx.Rollback(context.Background())
 
-    imageID, _ := strconv.Atoi(c.Param("id"))
-    
-    // Ambil S3 key dengan row locking
-    var s3Key string
-    err = tx.QueryRow(context.Background(),
-        "SELECT s3_key FROM images WHERE id = $1 FOR UPDATE", imageID,
-    ).Scan(&s3Key)
+    // Pindahkan ke trash; row dan file baru dihapus permanen setelah masa retensi
+    var deletedAt time.Time
+    err = db.QueryRow(context.Background(),
+        "UPDATE images SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL RETURNING deleted_at", imageID,
+    ).Scan(&deletedAt)
 
     if err != nil {
         if err == pgx.ErrNoRows {
@@ -501,32 +673,7 @@ func deleteImage(c *gin.Context) {
         return
     }
 
-    // Hapus dari database DALAM TRANSAKSI
-    _, err = tx.Exec(context.Background(),
-        "DELETE FROM images WHERE id = $1", imageID,
-    )
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete record"})
-        return
-    }
-
-    // Hapus dari S3
-    _, err = s3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-        Bucket: aws.String("myport-crunchy-personal"),
-        Key:    aws.String(s3Key),
-    })
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "S3 delete failed"})
-        return
-    }
-
-    // Commit transaksi
-    if err := tx.Commit(context.Background()); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
-        return
-    }
-
-    c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
+    c.JSON(http.StatusOK, gin.H{"message": "Image moved to trash", "purge_at": deletedAt.Add(trashRetention())})
 }
 
 func updateImage(c *gin.Context) {
@@ -559,7 +706,7 @@ func updateImage(c *gin.Context) {
     // Check if image exists and lock row
     var currentS3Key string
     err = tx.QueryRow(context.Background(),
-        "SELECT s3_key FROM images WHERE id = $1 FOR UPDATE", imageID,
+        "SELECT s3_key FROM images WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", imageID,
     ).Scan(&currentS3Key)
 
     if err != nil {
@@ -693,30 +840,151 @@ func addCategory(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Category added successfully"})
 }
 
+// Serves objects for the local and memory storage backends. Access is
+// granted by the signature in the URL produced by store.PresignGet.
+func serveStoredObject(c *gin.Context) {
+	verifier, ok := store.(storage.URLVerifier)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if err := verifier.VerifyURL(http.MethodGet, key, c.Query("expires"), c.Query("signature")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired URL"})
+		return
+	}
+
+	obj, err := store.Get(c.Request.Context(), key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage read failed"})
+		}
+		return
+	}
+	defer obj.Close()
+
+	c.DataFromReader(http.StatusOK, obj.Size, obj.ContentType, obj, nil)
+}
+
 func myRouter(r *gin.RouterGroup) {
 	// Routes
 	r.GET("/pingthefuckoutofme", ping)
     r.POST("/login", LoginUserHandler)
 	r.POST("/create", createUserHandler)
 	r.POST("/logout", LogoutHandlerGin)
+	r.POST("/refresh-token", RefreshTokenHandlerGin)
+	r.POST("/verify-email", verifyEmail)
+	r.POST("/verify-email/resend", resendVerification)
+	r.POST("/forgot-password", forgotPassword)
+	r.POST("/reset-password", resetPassword)
+	r.GET("/storage/*key", serveStoredObject)
+	r.PUT("/storage/*key", receiveStoredObject)
+	r.GET("/carousel", getCarousel)
+	r.GET("/share/:token", resolveShareLink)
+	r.POST("/share/:token", resolveShareLink)
+	// tus discovery, clients ask before they authenticate
+	r.OPTIONS("/uploads/tus", tusOptions)
 
 	authRoutes := r.Use(AuthGinMiddleware()) 
 	{
 		authRoutes.GET("/me", func(c *gin.Context) {
             username := c.MustGet("username").(string)
-            c.JSON(http.StatusOK, gin.H{"username": username})
+            c.JSON(http.StatusOK, gin.H{"username": username, "role": c.GetString("role")})
         })
 
+		// Sessions
+		r.GET("/sessions", getSessions)
+		r.DELETE("/sessions/:id", revokeSession)
+		r.POST("/logout-all", logoutEverywhere)
+	}
+
+	// Private collection, every role can browse it
+	read := r.Group("", RequirePermission(permRead))
+	{
+		read.GET("/categories", getCategories)
+		read.GET("/images", getAllImages)
+		read.GET("/images/duplicates", getDuplicateClusters)
+		read.GET("/export", exportImages)
+		read.GET("/image/:id", getOneImage)
+		read.GET("/image/:id/raw", serveImageRaw)
+		read.GET("/image/:id/raw/:variant", serveImageVariant)
+		read.GET("/image/:id/versions", getImageVersions)
+	}
+
+	// Changes to content: owners and editors
+	write := r.Group("", RequirePermission(permWrite))
+	{
 		// Image Categories
-		r.GET("/categories", getCategories)
-		r.POST("/categories", addCategory)
+		write.POST("/categories", addCategory)
+		write.PUT("/categories/:id/watermark", setCategoryWatermark)
 
 		// Images
-		r.POST("/imgupl", uploadImage)
-		r.GET("/images", getAllImages)
-		r.GET("/image/:id", getOneImage)
-		r.DELETE("/imgdel/:id", deleteImage)
-		r.PUT("/imgupd/:id", updateImage)
+		write.POST("/imgupl", uploadImage)
+		write.POST("/imgupl/batch", uploadImageBatch)
+		write.DELETE("/imgdel/:id", deleteImage)
+		write.PUT("/imgupd/:id", updateImage)
+
+		// File replacement and version history
+		write.POST("/image/:id/file", replaceImageFile)
+		write.POST("/image/:id/versions/:version/revert", revertImageVersion)
+
+		// Watermark for public and shared copies
+		write.GET("/watermark", getWatermark)
+		write.PUT("/watermark", updateWatermark)
+		write.PUT("/watermark/overlay", uploadWatermarkOverlay)
+		write.DELETE("/watermark/overlay", deleteWatermarkOverlay)
+		write.POST("/watermark/reprocess", reprocessWatermarks)
+		write.PUT("/image/:id/watermark", setImageWatermark)
+
+		// Share links
+		write.POST("/shares", createShareLink)
+		write.GET("/shares", getShareLinks)
+		write.DELETE("/shares/:id", revokeShareLink)
+
+		// Trash
+		write.GET("/trash", getTrash)
+		write.POST("/trash/:id/restore", restoreImage)
+		write.DELETE("/trash/:id", purgeImage)
+
+		// Direct-to-storage uploads
+		write.POST("/uploads", initiateUpload)
+		write.POST("/uploads/:id/complete", completeUpload)
+
+		// Resumable uploads (tus 1.0)
+		write.POST("/uploads/tus", tusCreate)
+		write.HEAD("/uploads/tus/:id", tusHead)
+		write.PATCH("/uploads/tus/:id", tusPatch)
+		write.DELETE("/uploads/tus/:id", tusDelete)
+
+		// Carousel
+		write.POST("/carousel", addCarouselSlide)
+		write.PUT("/carousel/order", reorderCarousel)
+		write.PUT("/carousel/:id", updateCarouselSlide)
+		write.DELETE("/carousel/:id", deleteCarouselSlide)
+	}
+
+	// Owners only
+	manage := r.Group("", RequirePermission(permManage))
+	{
+		// Users
+		manage.GET("/users", getUsers)
+		manage.PUT("/users/:username/role", setUserRole)
+
+		// Registration and invites
+		manage.GET("/registration", getRegistration)
+		manage.PUT("/registration", updateRegistration)
+		manage.POST("/invites", createInvite)
+		manage.GET("/invites", getInvites)
+		manage.DELETE("/invites/:id", revokeInvite)
+
+		// Maintenance
+		manage.POST("/maintenance/reconcile", reconcileStorage)
+		manage.POST("/maintenance/expire-uploads", expireUploads)
+		manage.POST("/maintenance/purge-trash", purgeTrash)
+		manage.POST("/maintenance/backfill-placeholders", backfillPlaceholders)
 	}
 	
 }
@@ -724,5 +992,6 @@ func myRouter(r *gin.RouterGroup) {
 // Serve as a Vercel function
 func Handler(w http.ResponseWriter, r *http.Request) {
 	// defer CloseDB()
+	connectOnce.Do(connect)
 	app.ServeHTTP(w, r)
 }
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type exportEntry struct {
+	ID          int    `json:"id"`
+	File        string `json:"file,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CategoryID  int    `json:"category_id"`
+	Category    string `json:"category"`
+	Error       string `json:"error,omitempty"`
+	s3Key       string
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._ -]+`)
+
+// exportFileName makes a name safe to use as a path segment inside the archive.
+func exportFileName(s string) string {
+	s = strings.TrimSpace(unsafeFileChars.ReplaceAllString(s, "_"))
+	if s == "" || s == "." || s == ".." {
+		return "untitled"
+	}
+	return s
+}
+
+// Streams a ZIP of original files: ?category_id= for one category, ?ids=1,2,3
+// for specific images, or everything when neither is given. Objects are
+// copied into the response one at a time, so memory use doesn't grow with the
+// archive. manifest.json is written last and lists every image, with an error
+// for any whose object could not be read.
+func exportImages(c *gin.Context) {
+	var (
+		categoryID *int
+		ids        []int
+		filename   = "collection.zip"
+	)
+	if v := c.Query("category_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+			return
+		}
+		categoryID = &id
+		filename = fmt.Sprintf("category-%d.zip", id)
+	}
+	for _, v := range c.QueryArray("ids") {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			id, err := strconv.Atoi(part)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID " + part})
+				return
+			}
+			ids = append(ids, id)
+		}
+	}
+	if ids != nil {
+		filename = "images.zip"
+	}
+
+	rows, err := db.Query(context.Background(),
+		`SELECT i.id, i.s3_key, i.name, i.description, c.id, c.name
+		FROM images i
+		JOIN categories c ON i.category_id = c.id
+		WHERE i.status = 'active' AND i.deleted_at IS NULL
+			AND ($1::int IS NULL OR i.category_id = $1)
+			AND ($2::int[] IS NULL OR i.id = ANY($2))
+		ORDER BY c.name, i.id`, categoryID, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	var entries []exportEntry
+	for rows.Next() {
+		var e exportEntry
+		if err := rows.Scan(&e.ID, &e.s3Key, &e.Name, &e.Description, &e.CategoryID, &e.Category); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse data"})
+			return
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No images to export"})
+		return
+	}
+
+	// From here on the status line is sent, failures can only be noted in
+	// the manifest or cut the stream short
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	ctx := c.Request.Context()
+	zw := zip.NewWriter(c.Writer)
+	for i := range entries {
+		e := &entries[i]
+		e.File = path.Join(exportFileName(e.Category),
+			fmt.Sprintf("%d-%s%s", e.ID, exportFileName(e.Name), path.Ext(e.s3Key)))
+
+		if err := writeExportFile(ctx, zw, e); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("export %s: %v", e.s3Key, err)
+			e.Error = "File could not be read"
+			e.File = ""
+		}
+	}
+
+	manifest, err := zw.Create("manifest.json")
+	if err == nil {
+		enc := json.NewEncoder(manifest)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(gin.H{"count": len(entries), "images": entries})
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Printf("export: %v", err)
+	}
+}
+
+// writeExportFile copies the object of e into the archive. Images are
+// already compressed, so they are stored rather than deflated.
+func writeExportFile(ctx context.Context, zw *zip.Writer, e *exportEntry) error {
+	obj, err := store.Get(ctx, e.s3Key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     e.File,
+		Method:   zip.Store,
+		Modified: obj.LastModified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, obj)
+	return err
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+)
+
+// Registration modes.
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite-only"
+	RegistrationClosed = "closed"
+)
+
+const defaultInviteTTL = 7 * 24 * time.Hour
+
+func registrationMode(ctx context.Context, q dbConn) (string, error) {
+	var mode string
+	err := q.QueryRow(ctx, `SELECT mode FROM registration_settings WHERE id = 1`).Scan(&mode)
+	if err == pgx.ErrNoRows {
+		return RegistrationInvite, nil
+	}
+	return mode, err
+}
+
+// consumeInvite uses up one registration of code and returns the role it
+// grants. ok is false when the code is unknown, revoked, expired or used up.
+func consumeInvite(ctx context.Context, tx pgx.Tx, code string) (role string, ok bool, err error) {
+	err = tx.QueryRow(ctx,
+		`UPDATE invite_codes SET uses = uses + 1
+		WHERE code_hash = $1 AND revoked_at IS NULL AND expires_at > now() AND uses < max_uses
+		RETURNING role`, hashToken(code),
+	).Scan(&role)
+	if err == pgx.ErrNoRows {
+		return "", false, nil
+	}
+	return role, err == nil, err
+}
+
+func getRegistration(c *gin.Context) {
+	mode, err := registrationMode(context.Background(), db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mode": mode})
+}
+
+func updateRegistration(c *gin.Context) {
+	var input struct {
+		Mode string `json:"mode" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	switch input.Mode {
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be open, invite-only or closed"})
+		return
+	}
+
+	_, err := db.Exec(context.Background(),
+		`INSERT INTO registration_settings (id, mode) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET mode = EXCLUDED.mode, updated_at = now()`, input.Mode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mode": input.Mode})
+}
+
+// Issues an invite code. Like share tokens, the code is only returned here.
+func createInvite(c *gin.Context) {
+	var input struct {
+		Role      string     `json:"role"`
+		MaxUses   *int       `json:"max_uses"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if input.Role == "" {
+		input.Role = RoleViewer
+	}
+	if !validRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be owner, editor or viewer"})
+		return
+	}
+	maxUses := 1
+	if input.MaxUses != nil {
+		if *input.MaxUses < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_uses must be at least 1"})
+			return
+		}
+		maxUses = *input.MaxUses
+	}
+	expiresAt := time.Now().Add(defaultInviteTTL)
+	if input.ExpiresAt != nil {
+		if !input.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+		expiresAt = *input.ExpiresAt
+	}
+
+	code, codeHash, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+
+	var id int
+	err = db.QueryRow(context.Background(),
+		`INSERT INTO invite_codes (code_hash, role, max_uses, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		codeHash, input.Role, maxUses, expiresAt, c.MustGet("username").(string),
+	).Scan(&id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         id,
+		"code":       code,
+		"role":       input.Role,
+		"max_uses":   maxUses,
+		"expires_at": expiresAt,
+	})
+}
+
+// Lists invites that can still be used.
+func getInvites(c *gin.Context) {
+	rows, err := db.Query(context.Background(),
+		`SELECT id, role, max_uses, uses, expires_at, created_by, created_at
+		FROM invite_codes
+		WHERE revoked_at IS NULL AND expires_at > now() AND uses < max_uses
+		ORDER BY created_at DESC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer rows.Close()
+
+	invites := []gin.H{}
+	for rows.Next() {
+		var (
+			id, maxUses, uses    int
+			role, createdBy      string
+			expiresAt, createdAt time.Time
+		)
+		if err := rows.Scan(&id, &role, &maxUses, &uses, &expiresAt, &createdBy, &createdAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse data"})
+			return
+		}
+		invites = append(invites, gin.H{
+			"id":         id,
+			"role":       role,
+			"max_uses":   maxUses,
+			"uses":       uses,
+			"expires_at": expiresAt,
+			"created_by": createdBy,
+			"created_at": createdAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, invites)
+}
+
+func revokeInvite(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite ID"})
+		return
+	}
+
+	tag, err := db.Exec(context.Background(),
+		`UPDATE invite_codes SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked"})
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// withRegistration sets the registration mode for the test and puts the
+// previous one back afterwards. An owner account is created first, so sign
+// ups in the test are never the first account.
+func withRegistration(t *testing.T, mode string) string {
+	t.Helper()
+	ctx := context.Background()
+	owner := testUser(t, RoleOwner)
+	previous, err := registrationMode(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(ctx, `UPDATE registration_settings SET mode = $1 WHERE id = 1`, previous)
+	})
+	w := call(updateRegistration, jsonRequest(http.MethodPut, "/", `{"mode": "`+mode+`"}`), nil, owner, RoleOwner)
+	if w.Code != http.StatusOK {
+		t.Fatalf("registration mode: status %d: %s", w.Code, w.Body)
+	}
+	return owner
+}
+
+// testInvite creates an invite as owner and returns its code and id.
+func testInvite(t *testing.T, owner, role string, maxUses int) (string, int) {
+	t.Helper()
+	body := fmt.Sprintf(`{"role": %q, "max_uses": %d}`, role, maxUses)
+	w := call(createInvite, jsonRequest(http.MethodPost, "/", body), nil, owner, RoleOwner)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("invite: status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		ID   int    `json:"id"`
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(context.Background(), `DELETE FROM invite_codes WHERE id = $1`, resp.ID)
+	})
+	return resp.Code, resp.ID
+}
+
+// signUp registers a new account, with inviteCode if set, and returns the
+// response and the username. The account is removed when the test ends.
+func signUp(t *testing.T, inviteCode string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	username := "test-" + uuid.New().String()[:8]
+	t.Cleanup(func() {
+		ctx := context.Background()
+		db.Exec(ctx, `DELETE FROM password_reset_tokens WHERE username = $1`, username)
+		db.Exec(ctx, `DELETE FROM refresh_tokens WHERE username = $1`, username)
+		db.Exec(ctx, `DELETE FROM sessions WHERE username = $1`, username)
+		db.Exec(ctx, `DELETE FROM users WHERE username = $1`, username)
+	})
+	body, _ := json.Marshal(gin.H{
+		"username":    username,
+		"email":       username + "@example.com",
+		"password":    "secret-password",
+		"invite_code": inviteCode,
+	})
+	return call(createUserHandler, jsonRequest(http.MethodPost, "/", string(body)), nil, "", ""), username
+}
+
+func TestRegistrationModes(t *testing.T) {
+	needDB(t)
+
+	for _, tc := range []struct {
+		mode         string
+		withCode     bool
+		want         int
+		wantRole     string
+		inviteUsedUp bool
+	}{
+		{RegistrationOpen, false, http.StatusCreated, RoleViewer, false},
+		{RegistrationOpen, true, http.StatusCreated, RoleEditor, true},
+		{RegistrationInvite, false, http.StatusForbidden, "", false},
+		{RegistrationInvite, true, http.StatusCreated, RoleEditor, true},
+		{RegistrationClosed, false, http.StatusForbidden, "", false},
+		{RegistrationClosed, true, http.StatusForbidden, "", false},
+	} {
+		name := tc.mode + " without code"
+		if tc.withCode {
+			name = tc.mode + " with code"
+		}
+		t.Run(name, func(t *testing.T) {
+			owner := withRegistration(t, tc.mode)
+			code := ""
+			var inviteID int
+			if tc.withCode {
+				code, inviteID = testInvite(t, owner, RoleEditor, 1)
+			}
+
+			w, username := signUp(t, code)
+			if w.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+			if tc.wantRole != "" {
+				if role, err := userRole(context.Background(), db, username); err != nil || role != tc.wantRole {
+					t.Errorf("role %q (%v), want %s", role, err, tc.wantRole)
+				}
+			}
+			if tc.withCode {
+				var uses int
+				db.QueryRow(context.Background(), `SELECT uses FROM invite_codes WHERE id = $1`, inviteID).Scan(&uses)
+				if (uses == 1) != tc.inviteUsedUp {
+					t.Errorf("invite used %d times", uses)
+				}
+			}
+		})
+	}
+}
+
+func TestInviteLimits(t *testing.T) {
+	needDB(t)
+	owner := withRegistration(t, RegistrationInvite)
+
+	t.Run("max uses", func(t *testing.T) {
+		code, _ := testInvite(t, owner, RoleViewer, 2)
+		for i := 0; i < 2; i++ {
+			if w, _ := signUp(t, code); w.Code != http.StatusCreated {
+				t.Fatalf("use %d: status %d: %s", i+1, w.Code, w.Body)
+			}
+		}
+		if w, _ := signUp(t, code); w.Code != http.StatusForbidden {
+			t.Errorf("third use: status %d, want 403", w.Code)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		code, id := testInvite(t, owner, RoleViewer, 5)
+		db.Exec(context.Background(), `UPDATE invite_codes SET expires_at = $1 WHERE id = $2`, time.Now().Add(-time.Minute), id)
+		if w, _ := signUp(t, code); w.Code != http.StatusForbidden {
+			t.Errorf("status %d, want 403", w.Code)
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		code, id := testInvite(t, owner, RoleViewer, 5)
+		w := call(revokeInvite, jsonRequest(http.MethodDelete, "/", ""), gin.Params{{Key: "id", Value: strconv.Itoa(id)}}, owner, RoleOwner)
+		if w.Code != http.StatusOK {
+			t.Fatalf("revoke: status %d", w.Code)
+		}
+		if w, _ := signUp(t, code); w.Code != http.StatusForbidden {
+			t.Errorf("status %d, want 403", w.Code)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		if w, _ := signUp(t, "not-a-code"); w.Code != http.StatusForbidden {
+			t.Errorf("status %d, want 403", w.Code)
+		}
+	})
+
+	t.Run("failed sign up keeps the use", func(t *testing.T) {
+		code, id := testInvite(t, owner, RoleViewer, 1)
+		w, username := signUp(t, code)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		// The same username again is refused before the code is looked at
+		body := fmt.Sprintf(`{"username": %q, "email": "other-%s@example.com", "password": "secret-password", "invite_code": %q}`, username, username, code)
+		if w := call(createUserHandler, jsonRequest(http.MethodPost, "/", body), nil, "", ""); w.Code != http.StatusConflict {
+			t.Errorf("taken username: status %d, want 409", w.Code)
+		}
+		var uses int
+		db.QueryRow(context.Background(), `SELECT uses FROM invite_codes WHERE id = $1`, id).Scan(&uses)
+		if uses != 1 {
+			t.Errorf("invite used %d times, want 1", uses)
+		}
+	})
+}
+
+func TestInviteSingleUseConcurrent(t *testing.T) {
+	needDB(t)
+	owner := withRegistration(t, RegistrationInvite)
+	code, _ := testInvite(t, owner, RoleEditor, 1)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w, _ := signUp(t, code)
+			codes[i] = w.Code
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, c := range codes {
+		switch c {
+		case http.StatusCreated:
+			created++
+		case http.StatusForbidden:
+		default:
+			t.Errorf("unexpected status %d", c)
+		}
+	}
+	if created != 1 {
+		t.Errorf("statuses %v, want one sign up to get through", codes)
+	}
+}
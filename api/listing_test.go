@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"golang-api/storage"
+)
+
+// listingFixture builds what getAllImages has loaded for n images before
+// signing: one original and three variants in two formats each.
+func listingFixture(n int) ([]gin.H, []string, []variantRow) {
+	images := make([]gin.H, n)
+	keys := make([]string, n)
+	var variants []variantRow
+	for i := range images {
+		images[i] = gin.H{"id": i + 1, "name": fmt.Sprintf("image %d", i+1)}
+		keys[i] = "images/" + uuid.New().String() + ".jpg"
+		for _, name := range []string{"thumb", "medium", "large"} {
+			for _, format := range []string{"jpeg", "webp"} {
+				variants = append(variants, variantRow{
+					imageID: i + 1, name: name, format: format, width: 320, height: 240,
+					s3Key: "images/" + uuid.New().String() + "." + format,
+				})
+			}
+		}
+	}
+	return images, keys, variants
+}
+
+// signListingURLs runs the part of getAllImages after the queries.
+func signListingURLs(b *testing.B, images []gin.H, keys []string, variants []variantRow) {
+	ctx := context.Background()
+	if err := signListing(ctx, images, keys); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := variantURLs(ctx, variants); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkListing(b *testing.B) {
+	for _, n := range []int{1000, 10000} {
+		images, keys, variants := listingFixture(n)
+
+		b.Run(fmt.Sprintf("images=%d/cold", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				presigned = storage.NewURLCache(store, 2*time.Minute, 8)
+				signListingURLs(b, images, keys, variants)
+			}
+		})
+
+		b.Run(fmt.Sprintf("images=%d/warm", n), func(b *testing.B) {
+			b.ReportAllocs()
+			presigned = storage.NewURLCache(store, 2*time.Minute, 8)
+			signListingURLs(b, images, keys, variants)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				signListingURLs(b, images, keys, variants)
+			}
+		})
+	}
+}
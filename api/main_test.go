@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"golang-api/imaging"
+	"golang-api/mail"
+	"golang-api/storage"
+)
+
+// TestMain wires the package up in place of connect: in-memory storage
+// and, when TEST_DATABASE_URL points at a migrated database, Postgres.
+func TestMain(m *testing.M) {
+	store = storage.NewMemory("http://localhost/api/storage", []byte("test-signing-key"))
+	presigned = storage.NewURLCache(store, 2*time.Minute, 8)
+	mailer = mail.Log{}
+	accessTokenKey = []byte("test-access-token-key")
+
+	if url := os.Getenv("TEST_DATABASE_URL"); url != "" {
+		var err error
+		db, err = pgxpool.Connect(context.Background(), url)
+		if err != nil {
+			log.Fatalf("Unable to connect to test database: %v", err)
+		}
+	}
+
+	code := m.Run()
+	if db != nil {
+		db.Close()
+	}
+	os.Exit(code)
+}
+
+// needDB skips tests that need Postgres when there is none.
+func needDB(t *testing.T) {
+	t.Helper()
+	if db == nil {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+}
+
+// call runs handler as username with role, the way the auth middleware would
+// have left the context, and returns the response.
+func call(handler gin.HandlerFunc, req *http.Request, params gin.Params, username, role string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Params = params
+	c.Set("username", username)
+	c.Set("role", role)
+	handler(c)
+	return w
+}
+
+// testCategory creates a category that is removed, with its images, when the
+// test ends.
+func testCategory(t *testing.T) int {
+	t.Helper()
+	ctx := context.Background()
+	var id int
+	err := db.QueryRow(ctx, `INSERT INTO categories (name) VALUES ($1) RETURNING id`, "test-"+uuid.New().String()[:8]).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(ctx, `DELETE FROM images WHERE category_id = $1`, id)
+		db.Exec(ctx, `DELETE FROM categories WHERE id = $1`, id)
+	})
+	return id
+}
+
+// testPNG encodes a small image whose pixels depend on seed, so different
+// seeds are different files.
+func testPNG(t *testing.T, seed int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 20), uint8(seed * 37), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, "png"); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// multipartRequest builds a POST of fields and files (each sent as an
+// "image" part).
+func multipartRequest(t *testing.T, path string, fields map[string]string, files ...[]byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	for i, data := range files {
+		part, err := w.CreateFormFile("image", fmt.Sprintf("file%d.png", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+// testActiveImage stores testPNG(seed) and adds it to category as an active
+// image without derivatives. It returns the image id and its object key.
+func testActiveImage(t *testing.T, category, seed int) (int, string) {
+	t.Helper()
+	ctx := context.Background()
+	data := testPNG(t, seed)
+	key := "images/" + uuid.New().String() + ".png"
+	if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	var id int
+	err := db.QueryRow(ctx,
+		`INSERT INTO images (name, category_id, description, s3_key, content_type, size_bytes, width, height)
+		VALUES ($1, $2, '', $3, 'image/png', $4, 16, 12) RETURNING id`,
+		fmt.Sprintf("image %d", seed), category, key, len(data),
+	).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, key
+}
+
+// withFileMailer sends the test's mail to .eml files in a directory, which
+// it returns, instead of the log.
+func withFileMailer(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	m, err := mail.NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := mailer
+	mailer = m
+	t.Cleanup(func() { mailer = previous })
+	return dir
+}
+
+var mailToken = regexp.MustCompile(`token=(\S+)`)
+
+// mailedTokens returns the token of every link mailed to to.
+func mailedTokens(t *testing.T, dir, to string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokens []string
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := netmail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if msg.Header.Get("To") != to {
+			continue
+		}
+		body, _ := io.ReadAll(msg.Body)
+		if m := mailToken.FindSubmatch(body); m != nil {
+			tokens = append(tokens, string(m[1]))
+		}
+	}
+	return tokens
+}
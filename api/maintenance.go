@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-api/maintenance"
+)
+
+// reconcileGrace keeps reconciliation away from uploads still in flight.
+const reconcileGrace = time.Hour
+
+// Compares the images/ prefix in storage with the database. Dry run unless
+// called with ?fix=true.
+func reconcileStorage(c *gin.Context) {
+	report, err := maintenance.Reconcile(c.Request.Context(), db, store, maintenance.ReconcileOptions{
+		Fix:   c.Query("fix") == "true",
+		Grace: reconcileGrace,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Reconciliation failed", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Removes pending uploads that were never completed.
+func expireUploads(c *gin.Context) {
+	n, err := maintenance.ExpirePendingUploads(c.Request.Context(), db, store)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Expiring uploads failed", "detail": err.Error()})
+		return
+	}
+	tus, err := maintenance.ExpireTusUploads(c.Request.Context(), db, store)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Expiring resumable uploads failed", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"expired": n, "expired_resumable": tus})
+}
+
+// Fills in the placeholders (BlurHash, dominant color) of images uploaded
+// before they were computed, ?limit= images at a time. Call again with
+// ?after_id= set to the returned next_after_id until remaining is 0.
+func backfillPlaceholders(c *gin.Context) {
+	limit := 20
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+	afterID, _ := strconv.Atoi(c.Query("after_id"))
+
+	report, err := maintenance.BackfillPlaceholders(c.Request.Context(), db, store, afterID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Backfill failed", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"golang-api/mail"
+)
+
+const passwordResetTTL = 30 * time.Minute
+
+// background tracks work that carries on after its response was written, so
+// it can be waited for.
+var background sync.WaitGroup
+
+// Public: emails a reset link to the account with this address. The answer
+// is the same whether or not there is one, so it can't be used to find out
+// which addresses are registered. The lookup and the mail happen after the
+// response, otherwise how long it takes would tell.
+func forgotPassword(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	background.Add(1)
+	go func(email string) {
+		defer background.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := sendPasswordReset(ctx, email); err != nil {
+			log.Printf("password reset: %v", err)
+		}
+	}(input.Email)
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account uses that email, a reset link is on its way"})
+}
+
+// sendPasswordReset issues a reset token for the account using email, if
+// any, and mails it. Earlier tokens of the account stop working.
+func sendPasswordReset(ctx context.Context, email string) error {
+	var username string
+	err := db.QueryRow(ctx, `SELECT username FROM users WHERE email = $1`, email).Scan(&username)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(ctx,
+		`UPDATE password_reset_tokens SET used_at = now() WHERE username = $1 AND used_at IS NULL`, username)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO password_reset_tokens (token_hash, username, expires_at) VALUES ($1, $2, $3)`,
+		tokenHash, username, time.Now().Add(passwordResetTTL))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, choose a new password here:\n\n%s/reset-password?token=%s\n\nThe link works once and expires in %d minutes. If it wasn't you, ignore this email; your password stays as it is.\n",
+			username, appURL(), token, int(passwordResetTTL.Minutes())),
+	})
+}
+
+// Public: sets a new password with a token from forgotPassword. Every
+// session of the account is ended, so whoever knew the old password is
+// logged out too.
+func resetPassword(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if len(input.Password) < 6 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password terlalu pendek"})
+		return
+	}
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	// Spend the token; a second use finds used_at set
+	var username string
+	err = tx.QueryRow(context.Background(),
+		`UPDATE password_reset_tokens SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING username`, hashToken(input.Token),
+	).Scan(&username)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	// Hashing is slow, only do it for a real token
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Gagal hash password"})
+		return
+	}
+
+	// The link came by email, so the address is confirmed as well
+	tag, err := tx.Exec(context.Background(),
+		`UPDATE users SET password = $1, email_verified_at = COALESCE(email_verified_at, now())
+		WHERE username = $2`, string(hashedPassword), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+		return
+	}
+
+	if _, err := revokeSessionsTx(context.Background(), tx, username, `true`); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(context.Background()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	clearAuthCookies(c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated, please log in again"})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// requestReset asks for a reset link for email and returns the tokens mailed
+// for it.
+func requestReset(t *testing.T, email string) []string {
+	t.Helper()
+	dir := withFileMailer(t)
+	w := call(forgotPassword, jsonRequest(http.MethodPost, "/", fmt.Sprintf(`{"email": %q}`, email)), nil, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("forgot password: status %d: %s", w.Code, w.Body)
+	}
+	background.Wait()
+	return mailedTokens(t, dir, email)
+}
+
+func reset(token, password string) int {
+	body := fmt.Sprintf(`{"token": %q, "password": %q}`, token, password)
+	return call(resetPassword, jsonRequest(http.MethodPost, "/", body), nil, "", "").Code
+}
+
+func TestResetPassword(t *testing.T) {
+	needDB(t)
+	username := testUser(t, RoleEditor)
+	email := username + "@example.com"
+	sessionID := testSessionOf(t, username)
+
+	tokens := requestReset(t, email)
+	if len(tokens) != 1 {
+		t.Fatalf("%d reset mails, want 1", len(tokens))
+	}
+	if code := reset(tokens[0], "new-password"); code != http.StatusOK {
+		t.Fatalf("reset: status %d, want 200", code)
+	}
+	if code := login(username, "new-password"); code != http.StatusOK {
+		t.Errorf("login with the new password, which also confirms the email: status %d, want 200", code)
+	}
+	if live, err := checkSession(context.Background(), sessionID, username); err != nil || live {
+		t.Errorf("session from before the reset still live (%v)", err)
+	}
+
+	// Tokens work once
+	if code := reset(tokens[0], "another-password"); code != http.StatusBadRequest {
+		t.Errorf("second use: status %d, want 400", code)
+	}
+	if code := login(username, "another-password"); code != http.StatusUnauthorized {
+		t.Errorf("password changed by a spent token: login status %d", code)
+	}
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+	needDB(t)
+	username := testUser(t, RoleEditor)
+	email := username + "@example.com"
+
+	tokens := requestReset(t, email)
+	if len(tokens) != 1 {
+		t.Fatalf("%d reset mails, want 1", len(tokens))
+	}
+	db.Exec(context.Background(),
+		`UPDATE password_reset_tokens SET expires_at = $1 WHERE username = $2`, time.Now().Add(-time.Minute), username)
+	if code := reset(tokens[0], "new-password"); code != http.StatusBadRequest {
+		t.Errorf("expired token: status %d, want 400", code)
+	}
+}
+
+func TestResetPasswordNewTokenReplacesOld(t *testing.T) {
+	needDB(t)
+	username := testUser(t, RoleEditor)
+	email := username + "@example.com"
+
+	first := requestReset(t, email)
+	second := requestReset(t, email)
+	if len(first) != 1 || len(second) != 1 {
+		t.Fatalf("%d and %d reset mails, want 1 each", len(first), len(second))
+	}
+	if code := reset(first[0], "new-password"); code != http.StatusBadRequest {
+		t.Errorf("older token: status %d, want 400", code)
+	}
+	if code := reset(second[0], "new-password"); code != http.StatusOK {
+		t.Errorf("newest token: status %d, want 200", code)
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	needDB(t)
+	if tokens := requestReset(t, "nobody-here@example.com"); len(tokens) != 0 {
+		t.Errorf("%d mails for an unknown address", len(tokens))
+	}
+}
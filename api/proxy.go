@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+
+	"golang-api/storage"
+)
+
+// imageCacheControl is the Cache-Control header of proxied images, set with
+// DIMAS_IMAGE_CACHE_CONTROL. Replacing a file keeps its URL, so the default
+// lets browsers keep a copy for a few minutes and revalidate by ETag after.
+func imageCacheControl() string {
+	if v := os.Getenv("DIMAS_IMAGE_CACHE_CONTROL"); v != "" {
+		return v
+	}
+	return "private, max-age=300"
+}
+
+// Streams the original file of an image. Unlike presigned URLs the address
+// is stable, so browsers and CDNs can cache it.
+func serveImageRaw(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	var (
+		s3Key       string
+		contentType *string
+		size        *int64
+		sum         *string
+	)
+	err = db.QueryRow(context.Background(),
+		`SELECT s3_key, content_type, size_bytes, sha256 FROM images
+		WHERE id = $1 AND status = 'active' AND deleted_at IS NULL`, imageID,
+	).Scan(&s3Key, &contentType, &size, &sum)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	etag, ctype := "", ""
+	if sum != nil {
+		etag = `"` + *sum + `"`
+	}
+	if contentType != nil {
+		ctype = *contentType
+	}
+	serveObject(c, s3Key, ctype, size, etag)
+}
+
+// Streams one derivative of an image, ?format= picks the format (the
+// non-WebP one by default).
+func serveImageVariant(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	var (
+		s3Key, format string
+		size          *int64
+		sum           *string
+	)
+	err = db.QueryRow(context.Background(),
+		`SELECT v.s3_key, v.format, v.size_bytes, i.sha256
+		FROM image_variants v
+		JOIN images i ON i.id = v.image_id
+		WHERE i.id = $1 AND v.name = $2 AND ($3 = '' OR v.format = $3) AND NOT v.watermarked
+			AND i.status = 'active' AND i.deleted_at IS NULL
+		ORDER BY v.format = 'webp'
+		LIMIT 1`, imageID, c.Param("variant"), c.Query("format"),
+	).Scan(&s3Key, &format, &size, &sum)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	// Derivatives are rendered deterministically from the original
+	etag := ""
+	if sum != nil {
+		etag = `"` + *sum + "-" + c.Param("variant") + "." + format + `"`
+	}
+	serveObject(c, s3Key, "image/"+format, size, etag)
+}
+
+// serveObject streams key through http.ServeContent, which takes care of
+// Range, If-Range and If-None-Match. size may be nil for rows from before
+// sizes were recorded, the object is then looked up first. Rows from before
+// checksums and content types were recorded pass them empty: the ETag is
+// then a weak one from the key (new files always get a new key) and size,
+// and ServeContent sniffs the type from the first bytes.
+func serveObject(c *gin.Context, key, contentType string, size *int64, etag string) {
+	ctx := c.Request.Context()
+	if size == nil {
+		info, err := store.Stat(ctx, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage read failed"})
+			}
+			return
+		}
+		size = &info.Size
+	}
+
+	if contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	if etag == "" {
+		etag = fmt.Sprintf(`W/"%s-%d"`, key, *size)
+	}
+	c.Header("Cache-Control", imageCacheControl())
+	c.Header("ETag", etag)
+
+	body := storage.NewReadSeeker(ctx, store, key, *size)
+	defer body.Close()
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, body)
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServeImageInvalidID(t *testing.T) {
+	handlers := map[string]gin.HandlerFunc{"raw": serveImageRaw, "variant": serveImageVariant}
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/image/abc/raw/thumb", nil)
+			c.Params = gin.Params{{Key: "id", Value: "abc"}, {Key: "variant", Value: "thumb"}}
+
+			handler(c)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+)
+
+// Roles, from most to least privileged.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Permissions required by the route groups in myRouter.
+const (
+	// permRead browses the private collection.
+	permRead = "read"
+	// permWrite uploads, edits, shares and deletes content.
+	permWrite = "write"
+	// permManage manages users and runs maintenance.
+	permManage = "manage"
+)
+
+var rolePermissions = map[string]map[string]bool{
+	RoleOwner:  {permRead: true, permWrite: true, permManage: true},
+	RoleEditor: {permRead: true, permWrite: true},
+	RoleViewer: {permRead: true},
+}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RequirePermission lets requests through only when the role set by
+// AuthGinMiddleware has perm.
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !rolePermissions[c.GetString("role")][perm] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func userRole(ctx context.Context, q dbConn, username string) (string, error) {
+	var role string
+	err := q.QueryRow(ctx, `SELECT role FROM users WHERE username = $1`, username).Scan(&role)
+	return role, err
+}
+
+// Lists every account with its role.
+func getUsers(c *gin.Context) {
+	rows, err := db.Query(context.Background(), `SELECT username, email, role FROM users ORDER BY username`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer rows.Close()
+
+	users := []gin.H{}
+	for rows.Next() {
+		var username, email, role string
+		if err := rows.Scan(&username, &email, &role); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse data"})
+			return
+		}
+		users = append(users, gin.H{"username": username, "email": email, "role": role})
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// Changes the role of an account. Its sessions are ended so the new role
+// applies right away instead of when the access token runs out.
+func setUserRole(c *gin.Context) {
+	var input struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || !validRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be owner, editor or viewer"})
+		return
+	}
+	username := c.Param("username")
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	// Lock the owners so two demotions can't both pass the check below
+	var owners int
+	err = tx.QueryRow(context.Background(),
+		`SELECT count(*) FROM (SELECT 1 FROM users WHERE role = 'owner' FOR UPDATE) o`,
+	).Scan(&owners)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	current, err := userRole(context.Background(), tx, username)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+	if current == input.Role {
+		c.JSON(http.StatusOK, gin.H{"username": username, "role": current})
+		return
+	}
+	if current == RoleOwner && owners <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "The last owner can't be demoted"})
+		return
+	}
+
+	_, err = tx.Exec(context.Background(), `UPDATE users SET role = $1 WHERE username = $2`, input.Role, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(context.Background()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if _, err := revokeSessions(context.Background(), username, `true`); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Role changed, but ending sessions failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "role": input.Role})
+}
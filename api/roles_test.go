@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signIn creates an account with role and returns its access token cookie.
+func signIn(t *testing.T, role string) *http.Cookie {
+	t.Helper()
+	username := testUser(t, role)
+	token, err := GenerateAccessToken(username, testSessionOf(t, username), role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: "access_token", Value: token}
+}
+
+// serve sends a request through the router, signed in with cookie if set.
+func serve(method, path, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func TestRoutePermissions(t *testing.T) {
+	needDB(t)
+	cookies := map[string]*http.Cookie{}
+	for _, role := range []string{RoleViewer, RoleEditor, RoleOwner} {
+		cookies[role] = signIn(t, role)
+	}
+
+	// One request per group that changes nothing once it gets through: the
+	// writes are rejected as invalid input after the permission check
+	routes := []struct {
+		group        string
+		method, path string
+		body         string
+		allowed      []string
+	}{
+		{"session", http.MethodGet, "/api/me", "", []string{RoleViewer, RoleEditor, RoleOwner}},
+		{"session", http.MethodGet, "/api/sessions", "", []string{RoleViewer, RoleEditor, RoleOwner}},
+		{"read", http.MethodGet, "/api/categories", "", []string{RoleViewer, RoleEditor, RoleOwner}},
+		{"read", http.MethodGet, "/api/image/x/versions", "", []string{RoleViewer, RoleEditor, RoleOwner}},
+		{"write", http.MethodPost, "/api/categories", `{}`, []string{RoleEditor, RoleOwner}},
+		{"write", http.MethodPut, "/api/image/x/watermark", `{}`, []string{RoleEditor, RoleOwner}},
+		{"write", http.MethodDelete, "/api/trash/x", "", []string{RoleEditor, RoleOwner}},
+		{"manage", http.MethodGet, "/api/users", "", []string{RoleOwner}},
+		{"manage", http.MethodPut, "/api/users/nobody/role", `{"role": "admin"}`, []string{RoleOwner}},
+		{"manage", http.MethodPost, "/api/invites", `{"role": "admin"}`, []string{RoleOwner}},
+	}
+	for _, rt := range routes {
+		if w := serve(rt.method, rt.path, rt.body, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s signed out: status %d, want 401", rt.method, rt.path, w.Code)
+		}
+		for role, cookie := range cookies {
+			allowed := false
+			for _, r := range rt.allowed {
+				allowed = allowed || r == role
+			}
+			w := serve(rt.method, rt.path, rt.body, cookie)
+			switch {
+			case allowed && (w.Code == http.StatusForbidden || w.Code == http.StatusUnauthorized):
+				t.Errorf("%s %s (%s) as %s: status %d, want it let through", rt.method, rt.path, rt.group, role, w.Code)
+			case !allowed && w.Code != http.StatusForbidden:
+				t.Errorf("%s %s (%s) as %s: status %d, want 403", rt.method, rt.path, rt.group, role, w.Code)
+			}
+		}
+	}
+}
+
+func TestSetUserRoleLastOwner(t *testing.T) {
+	needDB(t)
+	ctx := context.Background()
+	var others int
+	db.QueryRow(ctx, `SELECT count(*) FROM users WHERE role = 'owner'`).Scan(&others)
+	if others > 0 {
+		t.Skip("the test database has owners of its own")
+	}
+
+	first, second := testUser(t, RoleOwner), testUser(t, RoleOwner)
+	setRole := func(username, role string) int {
+		req := jsonRequest(http.MethodPut, "/", `{"role": "`+role+`"}`)
+		return call(setUserRole, req, gin.Params{{Key: "username", Value: username}}, first, RoleOwner).Code
+	}
+
+	if code := setRole(first, RoleEditor); code != http.StatusOK {
+		t.Fatalf("demoting one of two owners: status %d, want 200", code)
+	}
+	if code := setRole(second, RoleViewer); code != http.StatusConflict {
+		t.Errorf("demoting the last owner: status %d, want 409", code)
+	}
+	if role, _ := userRole(ctx, db, second); role != RoleOwner {
+		t.Errorf("last owner is now %s", role)
+	}
+	if code := setRole(second, RoleOwner); code != http.StatusOK {
+		t.Errorf("setting the role it already has: status %d, want 200", code)
+	}
+
+	// Demoting ends the sessions so the new role applies right away
+	sessionID := testSessionOf(t, first)
+	if code := setRole(first, RoleViewer); code != http.StatusOK {
+		t.Fatalf("status %d, want 200", code)
+	}
+	if live, err := checkSession(ctx, sessionID, first); err != nil || live {
+		t.Errorf("session still live after a role change (%v)", err)
+	}
+}
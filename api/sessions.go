@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// lastSeenInterval limits how often a session's last_seen_at is written.
+const lastSeenInterval = time.Minute
+
+// startSession records a new session for username on this device and sets
+// its access and refresh cookies.
+func startSession(c *gin.Context, username string) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	role, err := userRole(ctx, tx, username)
+	if err != nil {
+		return err
+	}
+
+	sessionID := uuid.New().String()
+	_, err = tx.Exec(ctx,
+		`INSERT INTO sessions (id, username, user_agent, ip, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		sessionID, username, c.Request.UserAgent(), c.ClientIP(), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return err
+	}
+	refreshToken, err := issueRefreshToken(ctx, tx, username, sessionID)
+	if err != nil {
+		return err
+	}
+	accessToken, err := GenerateAccessToken(username, sessionID, role)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	setAuthCookies(c, accessToken, refreshToken)
+	return nil
+}
+
+// checkSession reports whether sessionID of username is still live and
+// records the request as its last activity.
+func checkSession(ctx context.Context, sessionID, username string) (bool, error) {
+	var lastSeen time.Time
+	err := db.QueryRow(ctx,
+		`SELECT last_seen_at FROM sessions
+		WHERE id = $1 AND username = $2 AND revoked_at IS NULL AND expires_at > now()`,
+		sessionID, username,
+	).Scan(&lastSeen)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if time.Since(lastSeen) > lastSeenInterval {
+		db.Exec(ctx, `UPDATE sessions SET last_seen_at = now() WHERE id = $1`, sessionID)
+	}
+	return true, nil
+}
+
+// revokeSessions ends the sessions of username matching cond (with $2 as
+// its argument, if any) along with their refresh tokens. It returns how many
+// were live.
+func revokeSessions(ctx context.Context, username, cond string, args ...interface{}) (int64, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	n, err := revokeSessionsTx(ctx, tx, username, cond, args...)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit(ctx)
+}
+
+// revokeSessionsTx is revokeSessions inside the caller's transaction.
+func revokeSessionsTx(ctx context.Context, tx pgx.Tx, username, cond string, args ...interface{}) (int64, error) {
+	tag, err := tx.Exec(ctx,
+		`UPDATE sessions SET revoked_at = now()
+		WHERE username = $1 AND revoked_at IS NULL AND `+cond,
+		append([]interface{}{username}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE refresh_tokens t SET revoked_at = now()
+		FROM sessions s
+		WHERE t.family_id = s.id AND t.revoked_at IS NULL AND s.username = $1 AND s.revoked_at IS NOT NULL`,
+		username)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// sessionFromCookie reads the access token even when it has expired, so
+// logging out works after a long idle.
+func sessionFromCookie(c *gin.Context) *Claims {
+	cookie, err := c.Request.Cookie("access_token")
+	if err != nil {
+		return nil
+	}
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
+		return accessTokenKey, nil
+	}, jwt.WithoutClaimsValidation())
+	if err != nil || claims.SessionID == "" {
+		return nil
+	}
+	return claims
+}
+
+// Lists the live sessions of the current user, most recently active first.
+func getSessions(c *gin.Context) {
+	rows, err := db.Query(context.Background(),
+		`SELECT id, user_agent, ip, created_at, last_seen_at, expires_at FROM sessions
+		WHERE username = $1 AND revoked_at IS NULL AND expires_at > now()
+		ORDER BY last_seen_at DESC`, c.MustGet("username").(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer rows.Close()
+
+	current := c.GetString("session_id")
+	sessions := []gin.H{}
+	for rows.Next() {
+		var (
+			id, userAgent, ip              string
+			createdAt, lastSeen, expiresAt time.Time
+		)
+		if err := rows.Scan(&id, &userAgent, &ip, &createdAt, &lastSeen, &expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse data"})
+			return
+		}
+		sessions = append(sessions, gin.H{
+			"id":           id,
+			"user_agent":   userAgent,
+			"ip":           ip,
+			"created_at":   createdAt,
+			"last_seen_at": lastSeen,
+			"expires_at":   expiresAt,
+			"current":      id == current,
+		})
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// Revokes one session of the current user. Revoking the current one logs
+// out this device.
+func revokeSession(c *gin.Context) {
+	id := c.Param("id")
+	n, err := revokeSessions(context.Background(), c.MustGet("username").(string), `id = $2`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if id == c.GetString("session_id") {
+		clearAuthCookies(c)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// Logs the current user out of every device, this one included.
+func logoutEverywhere(c *gin.Context) {
+	n, err := revokeSessions(context.Background(), c.MustGet("username").(string), `true`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	clearAuthCookies(c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out everywhere", "revoked": n})
+}
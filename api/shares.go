@@ -0,0 +1,345 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultShareTTL = 7 * 24 * time.Hour
+
+// Creates a share link for one image or one category. The token is only
+// returned here; the database keeps a hash of it.
+func createShareLink(c *gin.Context) {
+	var input struct {
+		ImageID    *int       `json:"image_id"`
+		CategoryID *int       `json:"category_id"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+		Password   string     `json:"password"`
+		MaxViews   *int       `json:"max_views"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if (input.ImageID == nil) == (input.CategoryID == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Exactly one of image_id or category_id is required"})
+		return
+	}
+	expiresAt := time.Now().Add(defaultShareTTL)
+	if input.ExpiresAt != nil {
+		if !input.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+		expiresAt = *input.ExpiresAt
+	}
+	if input.MaxViews != nil && *input.MaxViews < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_views must be at least 1"})
+		return
+	}
+
+	// Trashed images can't be shared
+	var exists bool
+	if input.ImageID != nil {
+		err := db.QueryRow(context.Background(),
+			`SELECT EXISTS (SELECT 1 FROM images WHERE id = $1 AND status = 'active' AND deleted_at IS NULL)`,
+			*input.ImageID,
+		).Scan(&exists)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Image does not exist"})
+			return
+		}
+	}
+
+	var passwordHash *string
+	if input.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Gagal hash password"})
+			return
+		}
+		s := string(hashed)
+		passwordHash = &s
+	}
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+
+	var id int
+	err = db.QueryRow(context.Background(),
+		`INSERT INTO share_links (token_hash, image_id, category_id, password_hash, expires_at, max_views, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		tokenHash, input.ImageID, input.CategoryID, passwordHash, expiresAt, input.MaxViews,
+		c.MustGet("username").(string),
+	).Scan(&id)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category does not exist"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         id,
+		"token":      token,
+		"path":       "/api/share/" + token,
+		"expires_at": expiresAt,
+		"max_views":  input.MaxViews,
+	})
+}
+
+// Lists links that can still be opened: not revoked, not expired and with
+// views left. Owners see every link, others only their own.
+func getShareLinks(c *gin.Context) {
+	rows, err := db.Query(context.Background(),
+		`SELECT id, image_id, category_id, password_hash IS NOT NULL, expires_at, max_views, views, created_by, created_at
+		FROM share_links
+		WHERE revoked_at IS NULL AND expires_at > now() AND (max_views IS NULL OR views < max_views)
+			AND ($1 OR created_by = $2)
+		ORDER BY created_at DESC`, c.GetString("role") == RoleOwner, c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer rows.Close()
+
+	links := []gin.H{}
+	for rows.Next() {
+		var (
+			id, views            int
+			imageID, categoryID  *int
+			maxViews             *int
+			hasPassword          bool
+			expiresAt, createdAt time.Time
+			createdBy            string
+		)
+		if err := rows.Scan(&id, &imageID, &categoryID, &hasPassword, &expiresAt, &maxViews, &views, &createdBy, &createdAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse data"})
+			return
+		}
+		links = append(links, gin.H{
+			"id":           id,
+			"image_id":     imageID,
+			"category_id":  categoryID,
+			"has_password": hasPassword,
+			"expires_at":   expiresAt,
+			"max_views":    maxViews,
+			"views":        views,
+			"created_by":   createdBy,
+			"created_at":   createdAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, links)
+}
+
+// Revokes a link; like the listing, only owners can revoke other users' links.
+func revokeShareLink(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link ID"})
+		return
+	}
+
+	tag, err := db.Exec(context.Background(),
+		`UPDATE share_links SET revoked_at = now()
+		WHERE id = $1 AND revoked_at IS NULL AND ($2 OR created_by = $3)`,
+		id, c.GetString("role") == RoleOwner, c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
+}
+
+// Public: resolves a token to the shared image or category. A password, if
+// the link has one, comes in the X-Share-Password header or as "password"
+// in a JSON body. Every successful resolve counts as a view; links to
+// something that no longer exists answer 410 without using one up.
+func resolveShareLink(c *gin.Context) {
+	var (
+		id                  int
+		imageID, categoryID *int
+		passwordHash        *string
+		expiresAt           time.Time
+		revokedAt           *time.Time
+		maxViews            *int
+		views               int
+	)
+	err := db.QueryRow(context.Background(),
+		`SELECT id, image_id, category_id, password_hash, expires_at, revoked_at, max_views, views
+		FROM share_links WHERE token_hash = $1`, hashToken(c.Param("token")),
+	).Scan(&id, &imageID, &categoryID, &passwordHash, &expiresAt, &revokedAt, &maxViews, &views)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+	if revokedAt != nil || !expiresAt.After(time.Now()) || (maxViews != nil && views >= *maxViews) {
+		c.JSON(http.StatusGone, gin.H{"error": "Share link is no longer available"})
+		return
+	}
+
+	if passwordHash != nil {
+		password := c.GetHeader("X-Share-Password")
+		if password == "" {
+			var body struct {
+				Password string `json:"password"`
+			}
+			c.ShouldBindJSON(&body)
+			password = body.Password
+		}
+		if password == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Password required", "password_required": true})
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(*passwordHash), []byte(password)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password", "password_required": true})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	response := gin.H{"expires_at": expiresAt}
+	if maxViews != nil {
+		response["views_left"] = *maxViews - views - 1
+	}
+
+	if imageID != nil {
+		images, err := sharedImages(ctx, `i.id = $1`, *imageID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load image"})
+			return
+		}
+		if len(images) == 0 {
+			c.JSON(http.StatusGone, gin.H{"error": "Shared image is not available"})
+			return
+		}
+		response["scope"] = "image"
+		response["image"] = images[0]
+	} else {
+		var name string
+		err := db.QueryRow(context.Background(), `SELECT name FROM categories WHERE id = $1`, *categoryID).Scan(&name)
+		if err != nil {
+			c.JSON(http.StatusGone, gin.H{"error": "Shared category no longer exists"})
+			return
+		}
+		images, err := sharedImages(ctx, `i.category_id = $1`, *categoryID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load images"})
+			return
+		}
+		response["scope"] = "category"
+		response["category"] = gin.H{"id": *categoryID, "name": name}
+		response["images"] = images
+	}
+
+	// Only now that there is something to show, count the view; the conditions
+	// are checked again so concurrent opens can't go past max_views
+	tag, err := db.Exec(context.Background(),
+		`UPDATE share_links SET views = views + 1
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > now() AND (max_views IS NULL OR views < max_views)`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "Share link is no longer available"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// sharedImages loads the active images matching cond (with one argument)
+// along with their URLs, shaped like the entries of getAllImages. Images
+// without a public URL are left out.
+func sharedImages(ctx context.Context, cond string, arg interface{}) ([]gin.H, error) {
+	rows, err := db.Query(context.Background(),
+		`SELECT i.id, i.name, c.name, i.description, i.width, i.height, i.blurhash, i.dominant_color
+		FROM images i
+		JOIN categories c ON i.category_id = c.id
+		WHERE `+cond+` AND i.status = 'active' AND i.deleted_at IS NULL
+		ORDER BY i.id`, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []gin.H{}
+	var ids []int
+	for rows.Next() {
+		var (
+			id                   int
+			name, category, desc string
+			width, height        *int
+			blurhash, color      *string
+		)
+		if err := rows.Scan(&id, &name, &category, &desc, &width, &height, &blurhash, &color); err != nil {
+			return nil, err
+		}
+		images = append(images, gin.H{
+			"id":             id,
+			"name":           name,
+			"category":       category,
+			"description":    desc,
+			"width":          width,
+			"height":         height,
+			"blurhash":       blurhash,
+			"dominant_color": color,
+		})
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Watermarked copies where the watermark applies, never the clean original
+	urls, err := publicImageURLs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := loadVariantURLs(ctx, ids, true)
+	if err != nil {
+		return nil, err
+	}
+	// Images still waiting for their watermarked copy are left out
+	shown := []gin.H{}
+	for i, id := range ids {
+		url, ok := urls[id]
+		if !ok {
+			continue
+		}
+		images[i]["url"] = url
+		if v, ok := variants[id]; ok {
+			images[i]["variants"] = v
+		} else {
+			images[i]["variants"] = gin.H{}
+		}
+		shown = append(shown, images[i])
+	}
+	return shown, nil
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-api/maintenance"
+)
+
+// trashRetention is how long deleted images stay restorable, set in days
+// with DIMAS_TRASH_RETENTION_DAYS (default 30).
+func trashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("DIMAS_TRASH_RETENTION_DAYS"))
+	if err != nil || days < 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func getTrash(c *gin.Context) {
+	rows, err := db.Query(context.Background(),
+		`SELECT i.id, i.s3_key, i.name, c.name, i.description, i.deleted_at
+		FROM images i
+		JOIN categories c ON i.category_id = c.id
+		WHERE i.deleted_at IS NOT NULL
+		ORDER BY i.deleted_at DESC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer rows.Close()
+
+	retention := trashRetention()
+	images := []gin.H{}
+	for rows.Next() {
+		var (
+			id                          int
+			s3Key, name, category, desc string
+			deletedAt                   time.Time
+		)
+		if err := rows.Scan(&id, &s3Key, &name, &category, &desc, &deletedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse data"})
+			return
+		}
+
+		// Trashed images are still in storage, so the owner can preview them
+		url, err := presigned.PresignGet(c.Request.Context(), s3Key, 15*time.Minute)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "URL generation failed for " + s3Key})
+			return
+		}
+
+		images = append(images, gin.H{
+			"id":          id,
+			"name":        name,
+			"s3_key":      s3Key,
+			"category":    category,
+			"description": desc,
+			"url":         url,
+			"deleted_at":  deletedAt,
+			"purge_at":    deletedAt.Add(retention),
+		})
+	}
+
+	c.JSON(http.StatusOK, images)
+}
+
+func restoreImage(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	result, err := db.Exec(context.Background(),
+		"UPDATE images SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL", imageID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found in trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image restored"})
+}
+
+// Permanently deletes one trashed image without waiting for the retention period.
+func purgeImage(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	err = maintenance.PurgeImage(c.Request.Context(), db, store, imageID)
+	if err != nil {
+		if errors.Is(err, maintenance.ErrNotInTrash) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found in trash"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Purge failed"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image deleted permanently"})
+}
+
+// Purges everything that has been in the trash longer than the retention period.
+func purgeTrash(c *gin.Context) {
+	n, err := maintenance.PurgeTrash(c.Request.Context(), db, store, trashRetention())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Purge failed", "purged": n, "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": n})
+}
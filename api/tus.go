@@ -0,0 +1,441 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"golang-api/maintenance"
+)
+
+// Resumable uploads following tus 1.0 (https://tus.io/protocols/resumable-upload)
+// with the creation, termination and expiration extensions. Upload-Metadata
+// carries the image fields: filename, name, category_id (required),
+// description and allow_duplicate.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusUploadTTL  = 24 * time.Hour
+)
+
+func tusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+}
+
+// tusResumable answers 412 to clients speaking another protocol version.
+// It returns false once a response has been written.
+func tusResumable(c *gin.Context) bool {
+	tusHeaders(c)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value2".
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q: %w", key, err)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+// Discovery: which version, extensions and size the server supports.
+func tusOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.Itoa(maxUploadSize))
+	c.Status(http.StatusNoContent)
+}
+
+// Creation: registers an upload of Upload-Length bytes and returns its URL
+// in Location.
+func tusCreate(c *gin.Context) {
+	if !tusResumable(c) {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length is required"})
+		return
+	}
+	if length > maxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File larger than %d bytes", maxUploadSize)})
+		return
+	}
+	meta, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata"})
+		return
+	}
+	if meta["category_id"] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "category_id metadata is required"})
+		return
+	}
+	if _, ok := meta["name"]; !ok && meta["filename"] != "" {
+		meta["name"] = strings.TrimSuffix(meta["filename"], filepath.Ext(meta["filename"]))
+	}
+
+	// No cron on Vercel, abandoned uploads are cleaned up as new ones start
+	if n, err := maintenance.ExpireTusUploads(context.Background(), db, store); err != nil {
+		log.Printf("expire resumable uploads: %v", err)
+	} else if n > 0 {
+		log.Printf("expired %d resumable uploads", n)
+	}
+
+	id := uuid.New().String()
+	expiresAt := time.Now().Add(tusUploadTTL)
+	metadata, _ := json.Marshal(meta)
+	_, err = db.Exec(context.Background(),
+		`INSERT INTO tus_uploads (id, upload_length, metadata, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		id, length, string(metadata), c.MustGet("username").(string), expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+id)
+	c.Header("Upload-Expires", expiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+type tusUpload struct {
+	ID        string
+	Length    int64
+	Offset    int64
+	Metadata  map[string]string
+	ImageID   *int
+	ExpiresAt time.Time
+}
+
+// loadTusUpload answers 404 for unknown uploads and those of other users,
+// and 410 for expired ones. It returns nil once a response has been written.
+func loadTusUpload(c *gin.Context) *tusUpload {
+	u := &tusUpload{ID: c.Param("id")}
+	var metadata string
+	err := db.QueryRow(context.Background(),
+		`SELECT upload_length, upload_offset, metadata::text, image_id, expires_at
+		FROM tus_uploads WHERE id = $1 AND created_by = $2`, u.ID, c.GetString("username"),
+	).Scan(&u.Length, &u.Offset, &metadata, &u.ImageID, &u.ExpiresAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.AbortWithStatus(http.StatusNotFound)
+		} else {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return nil
+	}
+	if u.ExpiresAt.Before(time.Now()) {
+		c.AbortWithStatus(http.StatusGone)
+		return nil
+	}
+	json.Unmarshal([]byte(metadata), &u.Metadata)
+	return u
+}
+
+func (u *tusUpload) writeHeaders(c *gin.Context) {
+	c.Header("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(u.Length, 10))
+	c.Header("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	if u.ImageID != nil {
+		c.Header("X-Image-Id", strconv.Itoa(*u.ImageID))
+	}
+}
+
+// Reports how many bytes have been received, so the client knows where to
+// resume.
+func tusHead(c *gin.Context) {
+	if !tusResumable(c) {
+		return
+	}
+	u := loadTusUpload(c)
+	if u == nil {
+		return
+	}
+	u.writeHeaders(c)
+	c.Status(http.StatusOK)
+}
+
+// Appends the body at Upload-Offset. Whatever arrived before a dropped
+// connection is kept. Once the last byte is in, the file is validated and
+// turned into an image like uploadImage does; the new id comes back in
+// X-Image-Id.
+func tusPatch(c *gin.Context) {
+	if !tusResumable(c) {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset is required"})
+		return
+	}
+
+	u := loadTusUpload(c)
+	if u == nil {
+		return
+	}
+	if offset != u.Offset {
+		u.writeHeaders(c)
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	remaining := u.Length - u.Offset
+	data, readErr := io.ReadAll(io.LimitReader(c.Request.Body, remaining+1))
+	if int64(len(data)) > remaining {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Body exceeds Upload-Length"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if len(data) > 0 {
+		// A dropped connection cancels the request context, but the bytes
+		// that did arrive are still worth keeping
+		saveCtx := context.WithoutCancel(ctx)
+		if !appendTusChunk(c, saveCtx, u, data) {
+			return
+		}
+	}
+	if readErr != nil {
+		// Usually the client is gone already; if not, it resumes from here
+		log.Printf("tus %s: body cut short at %d: %v", u.ID, u.Offset, readErr)
+		u.writeHeaders(c)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Body cut short, resume at Upload-Offset"})
+		return
+	}
+
+	if u.Offset == u.Length && u.ImageID == nil {
+		if !finishTusUpload(c, u) {
+			return
+		}
+	}
+
+	u.writeHeaders(c)
+	c.Status(http.StatusNoContent)
+}
+
+// appendTusChunk stores data as the chunk at u.Offset and advances the
+// offset. A concurrent PATCH for the same offset loses with 409. It returns
+// false once a response has been written.
+func appendTusChunk(c *gin.Context, ctx context.Context, u *tusUpload, data []byte) bool {
+	key := fmt.Sprintf("tus/%s/%012d-%s", u.ID, u.Offset, uuid.New().String())
+	if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage upload failed"})
+		return false
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		removeObjects(ctx, []string{key})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return false
+	}
+	defer tx.Rollback(context.Background())
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE tus_uploads SET upload_offset = upload_offset + $1
+		WHERE id = $2 AND upload_offset = $3`, len(data), u.ID, u.Offset)
+	if err == nil && tag.RowsAffected() == 1 {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO tus_upload_chunks (upload_id, chunk_offset, s3_key, size_bytes)
+			VALUES ($1, $2, $3, $4)`, u.ID, u.Offset, key, len(data))
+		if err == nil {
+			err = tx.Commit(ctx)
+		}
+	} else if err == nil {
+		removeObjects(ctx, []string{key})
+		c.AbortWithStatus(http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		removeObjects(ctx, []string{key})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+
+	u.Offset += int64(len(data))
+	return true
+}
+
+// finishTusUpload joins the chunks and creates the image from them. Invalid
+// files end the upload; on server errors it stays so the client can retry
+// with an empty PATCH at the final offset. Only the request that claims the
+// upload gets this far, others get 409 until it is done. It returns false
+// once an error response has been written.
+func finishTusUpload(c *gin.Context, u *tusUpload) bool {
+	ctx := c.Request.Context()
+	tag, err := db.Exec(context.Background(),
+		`UPDATE tus_uploads SET finalizing_at = now()
+		WHERE id = $1 AND upload_offset = upload_length AND image_id IS NULL
+			AND (finalizing_at IS NULL OR finalizing_at < now() - interval '5 minutes')`, u.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+	if tag.RowsAffected() == 0 {
+		// Another request has it; once finished the image id is set
+		err = db.QueryRow(context.Background(),
+			`SELECT image_id FROM tus_uploads WHERE id = $1`, u.ID).Scan(&u.ImageID)
+		if err == nil && u.ImageID != nil {
+			return true
+		}
+		u.writeHeaders(c)
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is being finalized"})
+		return false
+	}
+	// Let a retry claim it again after a server error
+	release := func() {
+		db.Exec(context.Background(), `UPDATE tus_uploads SET finalizing_at = NULL WHERE id = $1`, u.ID)
+	}
+
+	keys, err := tusChunkKeys(u.ID)
+	if err != nil {
+		release()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+
+	body := &chunkReader{ctx: ctx, keys: keys}
+	defer body.Close()
+	result, fail := createImage(ctx, body, newImage{
+		Name:           u.Metadata["name"],
+		CategoryID:     u.Metadata["category_id"],
+		Description:    u.Metadata["description"],
+		AllowDuplicate: u.Metadata["allow_duplicate"] == "true",
+	})
+	if fail != nil {
+		if fail.Status < http.StatusInternalServerError {
+			db.Exec(context.Background(), `DELETE FROM tus_uploads WHERE id = $1`, u.ID)
+			removeObjects(ctx, keys)
+		} else {
+			release()
+		}
+		c.JSON(fail.Status, fail.Body)
+		return false
+	}
+
+	imageID := result["id"].(int)
+	u.ImageID = &imageID
+	_, err = db.Exec(context.Background(), `UPDATE tus_uploads SET image_id = $1 WHERE id = $2`, imageID, u.ID)
+	if err != nil {
+		log.Printf("tus %s: record image %d: %v", u.ID, imageID, err)
+	}
+	db.Exec(context.Background(), `DELETE FROM tus_upload_chunks WHERE upload_id = $1`, u.ID)
+	removeObjects(ctx, keys)
+	return true
+}
+
+func tusChunkKeys(uploadID string) ([]string, error) {
+	rows, err := db.Query(context.Background(),
+		`SELECT s3_key FROM tus_upload_chunks WHERE upload_id = $1 ORDER BY chunk_offset`, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// chunkReader reads the chunk objects one after another.
+type chunkReader struct {
+	ctx  context.Context
+	keys []string
+	cur  io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			obj, err := store.Get(r.ctx, r.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.keys = obj, r.keys[1:]
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	return r.cur.Close()
+}
+
+// Termination: drops the upload and its chunks.
+func tusDelete(c *gin.Context) {
+	if !tusResumable(c) {
+		return
+	}
+	u := loadTusUpload(c)
+	if u == nil {
+		return
+	}
+
+	keys, err := tusChunkKeys(u.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if _, err := db.Exec(context.Background(), `DELETE FROM tus_uploads WHERE id = $1`, u.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	removeObjects(c.Request.Context(), keys)
+
+	c.Status(http.StatusNoContent)
+}
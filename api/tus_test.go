@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// tusClient sends tus requests straight to the handlers as one user.
+type tusClient struct {
+	t        *testing.T
+	username string
+}
+
+func newTusClient(t *testing.T) *tusClient {
+	username := "test-" + uuid.New().String()[:8]
+	t.Cleanup(func() {
+		db.Exec(context.Background(), `DELETE FROM tus_uploads WHERE created_by = $1`, username)
+	})
+	return &tusClient{t: t, username: username}
+}
+
+// create registers an upload of length bytes into category and returns its id.
+func (tc *tusClient) create(category int, length int) string {
+	tc.t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/uploads/tus", nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.Itoa(length))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("test.png"))+
+		",category_id "+base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(category))))
+	w := call(tusCreate, req, nil, tc.username, RoleEditor)
+	if w.Code != http.StatusCreated {
+		tc.t.Fatalf("create: status %d: %s", w.Code, w.Body)
+	}
+	return path.Base(w.Header().Get("Location"))
+}
+
+func (tc *tusClient) patch(id string, offset int, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/api/uploads/tus/"+id, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	return call(tusPatch, req, gin.Params{{Key: "id", Value: id}}, tc.username, RoleEditor)
+}
+
+func (tc *tusClient) head(id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodHead, "/api/uploads/tus/"+id, nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	return call(tusHead, req, gin.Params{{Key: "id", Value: id}}, tc.username, RoleEditor)
+}
+
+// cutShort yields data and then fails like a dropped connection.
+type cutShort struct{ data []byte }
+
+func (r *cutShort) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func imagesIn(t *testing.T, category int) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(context.Background(), `SELECT count(*) FROM images WHERE category_id = $1`, category).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestTusPatchWrongOffset(t *testing.T) {
+	needDB(t)
+	tc := newTusClient(t)
+	data := testPNG(t, 1)
+	id := tc.create(testCategory(t), len(data))
+
+	w := tc.patch(id, 10, bytes.NewReader(data[10:]))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status %d, want 409", w.Code)
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "0" {
+		t.Errorf("Upload-Offset %q, want 0", got)
+	}
+}
+
+func TestTusResumeAfterCutShortBody(t *testing.T) {
+	needDB(t)
+	tc := newTusClient(t)
+	category := testCategory(t)
+	data := testPNG(t, 2)
+	id := tc.create(category, len(data))
+
+	half := len(data) / 2
+	w := tc.patch(id, 0, &cutShort{data: data[:half]})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("cut short: status %d, want 400", w.Code)
+	}
+	if got := tc.head(id).Header().Get("Upload-Offset"); got != strconv.Itoa(half) {
+		t.Fatalf("offset after cut short body %s, want %d", got, half)
+	}
+
+	w = tc.patch(id, half, bytes.NewReader(data[half:]))
+	if w.Code != http.StatusNoContent || w.Header().Get("X-Image-Id") == "" {
+		t.Fatalf("resume: status %d image %q: %s", w.Code, w.Header().Get("X-Image-Id"), w.Body)
+	}
+	if n := imagesIn(t, category); n != 1 {
+		t.Errorf("%d images, want 1", n)
+	}
+}
+
+func TestTusFinalizesOnce(t *testing.T) {
+	needDB(t)
+	tc := newTusClient(t)
+	category := testCategory(t)
+	data := testPNG(t, 3)
+	id := tc.create(category, len(data))
+
+	w := tc.patch(id, 0, bytes.NewReader(data))
+	imageID := w.Header().Get("X-Image-Id")
+	if w.Code != http.StatusNoContent || imageID == "" {
+		t.Fatalf("upload: status %d image %q: %s", w.Code, imageID, w.Body)
+	}
+
+	// Retrying the last PATCH answers with the same image
+	w = tc.patch(id, len(data), bytes.NewReader(nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("X-Image-Id") != imageID {
+		t.Errorf("retry: status %d image %q, want 204 %s", w.Code, w.Header().Get("X-Image-Id"), imageID)
+	}
+	if n := imagesIn(t, category); n != 1 {
+		t.Errorf("%d images after the retry, want 1", n)
+	}
+}
+
+func TestTusFinalizingClaim(t *testing.T) {
+	needDB(t)
+	tc := newTusClient(t)
+	category := testCategory(t)
+	data := testPNG(t, 4)
+	id := tc.create(category, len(data))
+
+	// All bytes are in and another request is turning them into an image
+	db.Exec(context.Background(), `UPDATE tus_uploads SET finalizing_at = now() WHERE id = $1`, id)
+	w := tc.patch(id, 0, bytes.NewReader(data))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("claimed upload: status %d, want 409", w.Code)
+	}
+	if n := imagesIn(t, category); n != 0 {
+		t.Fatalf("%d images while another request holds the claim, want 0", n)
+	}
+
+	// A claim that old is from a request that died, so it is taken over
+	db.Exec(context.Background(), `UPDATE tus_uploads SET finalizing_at = now() - interval '10 minutes' WHERE id = $1`, id)
+	w = tc.patch(id, len(data), bytes.NewReader(nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("X-Image-Id") == "" {
+		t.Fatalf("stale claim: status %d: %s", w.Code, w.Body)
+	}
+	if n := imagesIn(t, category); n != 1 {
+		t.Errorf("%d images, want 1", n)
+	}
+}
+
+func TestTusOtherUsersUpload(t *testing.T) {
+	needDB(t)
+	owner, other := newTusClient(t), newTusClient(t)
+	data := testPNG(t, 5)
+	id := owner.create(testCategory(t), len(data))
+
+	if w := other.head(id); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD: status %d, want 404", w.Code)
+	}
+	if w := other.patch(id, 0, bytes.NewReader(data)); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH: status %d, want 404", w.Code)
+	}
+	if got := owner.head(id).Header().Get("Upload-Offset"); got != "0" {
+		t.Errorf("owner's offset %s after the other user's PATCH, want 0", got)
+	}
+}
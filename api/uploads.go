@@ -0,0 +1,385 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"golang-api/imaging"
+	"golang-api/maintenance"
+	"golang-api/storage"
+)
+
+const (
+	maxUploadSize    = 50 << 20
+	uploadURLTTL     = 15 * time.Minute
+	pendingUploadTTL = time.Hour
+)
+
+// Phase one of a direct upload: creates a pending images row and returns a
+// presigned PUT URL the browser uploads the file to.
+func initiateUpload(c *gin.Context) {
+	var input struct {
+		Filename    string `json:"filename" binding:"required"`
+		ContentType string `json:"content_type" binding:"required"`
+		Size        int64  `json:"size" binding:"required"`
+		Name        string `json:"name"`
+		CategoryID  int    `json:"category_id" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if !isValidImageType(input.ContentType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image format. Only PNG/JPEG/WEBP allowed"})
+		return
+	}
+	if input.Size <= 0 || input.Size > maxUploadSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("File size must be between 1 and %d bytes", maxUploadSize)})
+		return
+	}
+
+	// No cron on Vercel, so stale uploads are cleaned up whenever a new one starts
+	if n, err := maintenance.ExpirePendingUploads(context.Background(), db, store); err != nil {
+		log.Printf("expire pending uploads: %v", err)
+	} else if n > 0 {
+		log.Printf("expired %d pending uploads", n)
+	}
+
+	objectKey := fmt.Sprintf("images/%s%s", uuid.New().String(), strings.ToLower(filepath.Ext(input.Filename)))
+	expiresAt := time.Now().Add(pendingUploadTTL)
+
+	var imageID int
+	err := db.QueryRow(context.Background(),
+		`INSERT INTO images (name, category_id, description, s3_key, status, upload_expires_at, content_type, size_bytes)
+		VALUES ($1, $2, $3, $4, 'pending', $5, $6, $7) RETURNING id`,
+		input.Name, input.CategoryID, input.Description, objectKey, expiresAt, input.ContentType, input.Size,
+	).Scan(&imageID)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category does not exist"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	uploadURL, err := store.PresignPut(c.Request.Context(), objectKey, input.ContentType, input.Size, uploadURLTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "URL generation failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         imageID,
+		"s3_key":     objectKey,
+		"upload_url": uploadURL,
+		"method":     http.MethodPut,
+		"headers":    gin.H{"Content-Type": input.ContentType},
+		"expires_at": expiresAt,
+	})
+}
+
+// Phase two: checks the uploaded object against what was announced and
+// activates the row.
+func completeUpload(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	var (
+		s3Key, status, contentType string
+		size                       int64
+		expiresAt                  *time.Time
+	)
+	err = tx.QueryRow(context.Background(),
+		`SELECT s3_key, status, content_type, size_bytes, upload_expires_at
+		FROM images WHERE id = $1 FOR UPDATE`, imageID,
+	).Scan(&s3Key, &status, &contentType, &size, &expiresAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	if status != "pending" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload already completed"})
+		return
+	}
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload expired"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	info, err := store.Stat(ctx, s3Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File has not been uploaded yet"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage read failed"})
+		}
+		return
+	}
+	if info.Size != size {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded size does not match", "expected": size, "actual": info.Size})
+		return
+	}
+
+	// Don't trust the announced type, sniff and decode the stored bytes
+	obj, err := store.Get(ctx, s3Key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage read failed"})
+		return
+	}
+	data, decoded, err := readImage(obj)
+	obj.Close()
+	if err != nil {
+		respondImageError(c, err)
+		return
+	}
+	if decoded.MIMEType != contentType {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded file is not a valid " + contentType + " image"})
+		return
+	}
+
+	// The browser uploaded the raw file, so replace it with the stripped copy
+	clean, err := imaging.Sanitize(data, decoded)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process image"})
+		return
+	}
+
+	hashes := hashImage(clean)
+	duplicate, ok := checkDuplicate(c, tx, hashes, imageID)
+	if !ok {
+		return
+	}
+
+	if !bytes.Equal(clean.Data, data) {
+		err = store.Put(ctx, s3Key, bytes.NewReader(clean.Data), int64(len(clean.Data)), decoded.MIMEType)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage upload failed"})
+			return
+		}
+	}
+	metadata, _ := json.Marshal(clean.Metadata)
+	placeholder := imaging.NewPlaceholder(clean.Image)
+
+	variantKeys, err := generateVariants(ctx, tx, imageID, s3Key, clean.Image, decoded.Format)
+	if err != nil {
+		removeObjects(ctx, variantKeys)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Variant generation failed"})
+		return
+	}
+
+	_, err = tx.Exec(context.Background(),
+		`UPDATE images SET
+			status = 'active',
+			upload_expires_at = NULL,
+			size_bytes = $1,
+			metadata = $2,
+			width = $3,
+			height = $4,
+			sha256 = $5,
+			phash = $6,
+			blurhash = $7,
+			dominant_color = $8
+		WHERE id = $9`,
+		len(clean.Data), string(metadata), clean.Metadata.Width, clean.Metadata.Height,
+		hashes.SHA256, int64(hashes.PHash), placeholder.BlurHash, placeholder.Color, imageID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": imageID, "s3_key": s3Key, "message": "Image uploaded", "duplicate_of": duplicate})
+}
+
+var errUploadTooLarge = errors.New("upload exceeds maximum size")
+
+// readImage reads at most maxUploadSize bytes from r and validates them as an
+// image by content, see imaging.Inspect.
+func readImage(r io.Reader) ([]byte, *imaging.Decoded, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxUploadSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > maxUploadSize {
+		return nil, nil, errUploadTooLarge
+	}
+
+	decoded, err := imaging.Inspect(data, imaging.DefaultLimits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, decoded, nil
+}
+
+// uploadFailure is a failed upload together with the response it maps to.
+type uploadFailure struct {
+	Status int
+	Body   gin.H
+}
+
+func (f *uploadFailure) Error() string {
+	msg, _ := f.Body["error"].(string)
+	return msg
+}
+
+// imageFailure maps a readImage error to its response.
+func imageFailure(err error) *uploadFailure {
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		return &uploadFailure{http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File larger than %d bytes", maxUploadSize)}}
+	case errors.Is(err, imaging.ErrUnsupportedType):
+		return &uploadFailure{http.StatusBadRequest, gin.H{"error": "Invalid image format. Only PNG/JPEG/WEBP allowed"}}
+	case errors.Is(err, imaging.ErrTooLarge):
+		return &uploadFailure{http.StatusBadRequest, gin.H{"error": "Image dimensions too large"}}
+	case errors.Is(err, imaging.ErrCorrupt):
+		return &uploadFailure{http.StatusBadRequest, gin.H{"error": "Invalid image file"}}
+	default:
+		return &uploadFailure{http.StatusInternalServerError, gin.H{"error": "Failed to read upload"}}
+	}
+}
+
+// respondImageError turns a readImage error into a response.
+func respondImageError(c *gin.Context, err error) {
+	f := imageFailure(err)
+	c.JSON(f.Status, f.Body)
+}
+
+// newImage is the form data an uploaded image is created with.
+type newImage struct {
+	Name           string
+	CategoryID     string
+	Description    string
+	AllowDuplicate bool
+}
+
+// createImage validates and sanitizes one uploaded file, stores it with its
+// variants and inserts its images row, all in a transaction of its own. It
+// backs both the single and the batch upload endpoints.
+func createImage(ctx context.Context, r io.Reader, in newImage) (gin.H, *uploadFailure) {
+	// Validate file type from the bytes themselves; the part's Content-Type
+	// header is whatever the client claims
+	data, decoded, err := readImage(r)
+	if err != nil {
+		return nil, imageFailure(err)
+	}
+
+	// Pull out EXIF (GPS, camera, ...) and store a stripped, upright copy
+	clean, err := imaging.Sanitize(data, decoded)
+	if err != nil {
+		return nil, &uploadFailure{http.StatusInternalServerError, gin.H{"error": "Failed to process image"}}
+	}
+	metadata, _ := json.Marshal(clean.Metadata)
+
+	hashes := hashImage(clean)
+	placeholder := imaging.NewPlaceholder(clean.Image)
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return nil, &uploadFailure{http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"}}
+	}
+	defer tx.Rollback(context.Background())
+
+	// Tolak (atau tandai) gambar yang sudah pernah diupload
+	duplicate, fail := screenDuplicate(tx, hashes, 0, in.AllowDuplicate)
+	if fail != nil {
+		return nil, fail
+	}
+
+	objectKey := fmt.Sprintf("images/%s%s", uuid.New().String(), imaging.Extension(decoded.Format))
+
+	var imageID int
+	err = tx.QueryRow(context.Background(),
+		`INSERT INTO images (name, category_id, description, s3_key, content_type, size_bytes, metadata, width, height, sha256, phash, blurhash, dominant_color)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id`,
+		in.Name, in.CategoryID, in.Description, objectKey, decoded.MIMEType, len(clean.Data), string(metadata),
+		clean.Metadata.Width, clean.Metadata.Height, hashes.SHA256, int64(hashes.PHash),
+		placeholder.BlurHash, placeholder.Color,
+	).Scan(&imageID)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return nil, &uploadFailure{http.StatusBadRequest, gin.H{"error": "Category does not exist"}}
+		}
+		return nil, &uploadFailure{http.StatusInternalServerError, gin.H{"error": "Database error"}}
+	}
+
+	err = store.Put(ctx, objectKey, bytes.NewReader(clean.Data), int64(len(clean.Data)), decoded.MIMEType)
+	if err != nil {
+		return nil, &uploadFailure{http.StatusInternalServerError, gin.H{"error": "Storage upload failed"}}
+	}
+
+	// Generate resized variants (thumb/medium/large + webp)
+	variantKeys, err := generateVariants(ctx, tx, imageID, objectKey, clean.Image, decoded.Format)
+	if err != nil {
+		removeObjects(ctx, append(variantKeys, objectKey))
+		return nil, &uploadFailure{http.StatusInternalServerError, gin.H{"error": "Variant generation failed"}}
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		removeObjects(ctx, append(variantKeys, objectKey))
+		return nil, &uploadFailure{http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"}}
+	}
+
+	return gin.H{"id": imageID, "s3_key": objectKey, "message": "Image uploaded", "duplicate_of": duplicate}, nil
+}
+
+// Accepts PUTs to presigned URLs of the local and memory storage backends.
+func receiveStoredObject(c *gin.Context) {
+	verifier, ok := store.(storage.URLVerifier)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if err := verifier.VerifyURL(http.MethodPut, key, c.Query("expires"), c.Query("signature")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired URL"})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize)
+	err := store.Put(c.Request.Context(), key, body, c.Request.ContentLength, c.GetHeader("Content-Type"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage upload failed"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"golang-api/imaging"
+)
+
+// dbConn is satisfied by both the pool and a transaction.
+type dbConn interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// variantKey places a derivative next to its original:
+// images/<uuid>.png -> images/<uuid>_thumb.webp
+func variantKey(s3Key, variant, format string) string {
+	return strings.TrimSuffix(s3Key, path.Ext(s3Key)) + "_" + variant + imaging.Extension(format)
+}
+
+// generateVariants renders every derivative of img, stores them next to
+// s3Key and records them in image_variants through q, along with the
+// watermarked copies if a watermark applies to the image. It returns the
+// keys written so the caller can remove them if its transaction fails.
+func generateVariants(ctx context.Context, q dbConn, imageID int, s3Key string, img image.Image, srcFormat string) ([]string, error) {
+	renditions, err := imaging.Render(img, imaging.BaseFormat(img, srcFormat), nil)
+	if err != nil {
+		return nil, err
+	}
+	written, err := storeRenditions(ctx, q, imageID, s3Key, renditions, false)
+	if err != nil {
+		return written, err
+	}
+
+	wm, version, err := watermarkFor(ctx, q, imageID)
+	if err != nil {
+		return written, err
+	}
+	wmKeys, err := storeWatermarked(ctx, q, imageID, s3Key, img, srcFormat, wm, version)
+	return append(written, wmKeys...), err
+}
+
+// storeRenditions uploads renditions and upserts their image_variants rows.
+func storeRenditions(ctx context.Context, q dbConn, imageID int, s3Key string, renditions []imaging.Rendition, watermarked bool) ([]string, error) {
+	var written []string
+	for _, r := range renditions {
+		name := r.Variant
+		if watermarked {
+			name += "_wm"
+		}
+		key := variantKey(s3Key, name, r.Format)
+		if err := store.Put(ctx, key, bytes.NewReader(r.Data), int64(len(r.Data)), imaging.ContentType(r.Format)); err != nil {
+			return written, err
+		}
+		written = append(written, key)
+
+		_, err := q.Exec(ctx,
+			`INSERT INTO image_variants (image_id, name, format, s3_key, width, height, size_bytes, watermarked)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (image_id, name, format, watermarked) DO UPDATE SET
+				s3_key = EXCLUDED.s3_key,
+				width = EXCLUDED.width,
+				height = EXCLUDED.height,
+				size_bytes = EXCLUDED.size_bytes`,
+			imageID, r.Variant, r.Format, key, r.Width, r.Height, len(r.Data), watermarked,
+		)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// removeObjects deletes keys on a best-effort basis; a failure only leaves
+// an orphaned object behind.
+func removeObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		store.Delete(ctx, key)
+	}
+}
+
+// loadVariantURLs returns, per image id, a map of variant name to its
+// dimensions and one presigned URL per format, e.g.
+//
+//	{"thumb": {"width": 320, "height": 240, "jpeg": "https://...", "webp": "https://..."}}
+//
+// With public set, images the watermark applies to get their watermarked
+// derivatives, and none at all until those have been rendered.
+func loadVariantURLs(ctx context.Context, imageIDs []int, public bool) (map[int]gin.H, error) {
+	if len(imageIDs) == 0 {
+		return map[int]gin.H{}, nil
+	}
+
+	rows, err := db.Query(context.Background(),
+		`SELECT v.image_id, v.name, v.format, v.s3_key, v.width, v.height
+		FROM image_variants v
+		JOIN images i ON i.id = v.image_id
+		JOIN categories c ON c.id = i.category_id
+		CROSS JOIN watermark_settings w
+		WHERE v.image_id = ANY($1) AND v.watermarked = ($2 AND `+watermarkApplies+`)`, imageIDs, public)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []variantRow
+	for rows.Next() {
+		var v variantRow
+		if err := rows.Scan(&v.imageID, &v.name, &v.format, &v.s3Key, &v.width, &v.height); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return variantURLs(ctx, variants)
+}
+
+// variantRow is one derivative loaded by loadVariantURLs.
+type variantRow struct {
+	imageID, width, height int
+	name, format, s3Key    string
+}
+
+// variantURLs signs variants and groups them per image, in the shape
+// loadVariantURLs returns.
+func variantURLs(ctx context.Context, variants []variantRow) (map[int]gin.H, error) {
+	keys := make([]string, len(variants))
+	for i, v := range variants {
+		keys[i] = v.s3Key
+	}
+	urls, err := presigned.PresignGetMany(ctx, keys, 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[int]gin.H)
+	for _, v := range variants {
+		if result[v.imageID] == nil {
+			result[v.imageID] = gin.H{}
+		}
+		variant, ok := result[v.imageID][v.name].(gin.H)
+		if !ok {
+			variant = gin.H{"width": v.width, "height": v.height}
+			result[v.imageID][v.name] = variant
+		}
+		variant[v.format] = urls[v.s3Key]
+	}
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"golang-api/mail"
+)
+
+const (
+	emailVerificationTTL = 48 * time.Hour
+	// Audience of verification tokens, so no other token signed with the
+	// same key passes as one
+	verifyEmailAudience = "verify-email"
+)
+
+// appURL is the frontend links in emails point at, set with DIMAS_APP_URL.
+func appURL() string {
+	if v := os.Getenv("DIMAS_APP_URL"); v != "" {
+		return strings.TrimSuffix(v, "/")
+	}
+	return "https://marugo-porto.vercel.app"
+}
+
+type emailClaims struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// sendVerification mails username a link confirming email. The token is
+// signed rather than stored and names the address, so it stops working if
+// the address changes.
+func sendVerification(ctx context.Context, username, email string) error {
+	claims := &emailClaims{
+		Username: username,
+		Email:    email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{verifyEmailAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(emailVerificationTTL)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(accessTokenKey)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address to finish setting up your account:\n\n%s/verify-email?token=%s\n\nThe link expires in %d hours. If you didn't sign up, you can ignore this email.\n",
+			username, appURL(), token, int(emailVerificationTTL.Hours())),
+	})
+}
+
+func emailVerified(ctx context.Context, username string) (bool, error) {
+	var verified bool
+	err := db.QueryRow(ctx,
+		`SELECT email_verified_at IS NOT NULL FROM users WHERE username = $1`, username,
+	).Scan(&verified)
+	return verified, err
+}
+
+// Public: confirms the address a verification token was issued for.
+func verifyEmail(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	claims := &emailClaims{}
+	token, err := jwt.ParseWithClaims(input.Token, claims, func(token *jwt.Token) (interface{}, error) {
+		return accessTokenKey, nil
+	}, jwt.WithAudience(verifyEmailAudience), jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+
+	tag, err := db.Exec(context.Background(),
+		`UPDATE users SET email_verified_at = now()
+		WHERE username = $1 AND email = $2 AND email_verified_at IS NULL`, claims.Username, claims.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		// Either clicked twice or the address has changed since
+		verified, err := emailVerified(context.Background(), claims.Username)
+		if err != nil || !verified {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// Public: sends the verification email again. It takes the account's
+// credentials, since unverified accounts can't log in.
+func resendVerification(c *gin.Context) {
+	var input struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if !isValidUser(input.Username, input.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	var (
+		email    string
+		verified bool
+	)
+	err := db.QueryRow(context.Background(),
+		`SELECT email, email_verified_at IS NOT NULL FROM users WHERE username = $1`, input.Username,
+	).Scan(&email, &verified)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if verified {
+		c.JSON(http.StatusOK, gin.H{"message": "Email already verified"})
+		return
+	}
+
+	if err := sendVerification(c.Request.Context(), input.Username, email); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func login(username, password string) int {
+	body := fmt.Sprintf(`{"username": %q, "password": %q}`, username, password)
+	return call(LoginUserHandler, jsonRequest(http.MethodPost, "/", body), nil, "", "").Code
+}
+
+func verify(token string) int {
+	return call(verifyEmail, jsonRequest(http.MethodPost, "/", fmt.Sprintf(`{"token": %q}`, token)), nil, "", "").Code
+}
+
+func TestSignUpVerifiesEmail(t *testing.T) {
+	needDB(t)
+	withRegistration(t, RegistrationOpen)
+	dir := withFileMailer(t)
+
+	w, username := signUp(t, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("sign up: status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		VerificationSent bool `json:"verification_sent"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !resp.VerificationSent {
+		t.Error("verification_sent = false")
+	}
+
+	if code := login(username, "secret-password"); code != http.StatusForbidden {
+		t.Errorf("login before verifying: status %d, want 403", code)
+	}
+
+	tokens := mailedTokens(t, dir, username+"@example.com")
+	if len(tokens) != 1 {
+		t.Fatalf("%d verification mails, want 1", len(tokens))
+	}
+	if code := verify(tokens[0]); code != http.StatusOK {
+		t.Fatalf("verify: status %d, want 200", code)
+	}
+	if code := login(username, "secret-password"); code != http.StatusOK {
+		t.Errorf("login after verifying: status %d, want 200", code)
+	}
+
+	// Following the link twice is fine
+	if code := verify(tokens[0]); code != http.StatusOK {
+		t.Errorf("verify again: status %d, want 200", code)
+	}
+}
+
+func TestVerifyEmailRejectsChangedAddress(t *testing.T) {
+	needDB(t)
+	withRegistration(t, RegistrationOpen)
+	dir := withFileMailer(t)
+
+	w, username := signUp(t, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("sign up: status %d: %s", w.Code, w.Body)
+	}
+	tokens := mailedTokens(t, dir, username+"@example.com")
+	if len(tokens) != 1 {
+		t.Fatalf("%d verification mails, want 1", len(tokens))
+	}
+
+	db.Exec(context.Background(), `UPDATE users SET email = $1 WHERE username = $2`, "changed-"+username+"@example.com", username)
+	if code := verify(tokens[0]); code != http.StatusBadRequest {
+		t.Errorf("token for the old address: status %d, want 400", code)
+	}
+	if code := login(username, "secret-password"); code != http.StatusForbidden {
+		t.Errorf("login: status %d, want 403", code)
+	}
+
+	if code := verify("not-a-token"); code != http.StatusBadRequest {
+		t.Errorf("garbage token: status %d, want 400", code)
+	}
+}
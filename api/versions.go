@@ -0,0 +1,370 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"golang-api/imaging"
+)
+
+// archiveVersion copies the current file of imageID into image_versions and
+// drops its variant rows. It returns the dropped variant keys, which the
+// caller deletes from storage once its transaction has committed.
+func archiveVersion(ctx context.Context, tx pgx.Tx, imageID int) ([]string, error) {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO image_versions (image_id, version, s3_key, content_type, size_bytes, sha256, phash, metadata, width, height)
+		SELECT id, version, s3_key, content_type, size_bytes, sha256, phash, metadata, width, height
+		FROM images WHERE id = $1
+		ON CONFLICT (image_id, version) DO NOTHING`, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `DELETE FROM image_variants WHERE image_id = $1 RETURNING s3_key`, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// subtractKeys returns the keys of a that are not in b.
+func subtractKeys(a, b []string) []string {
+	skip := make(map[string]bool, len(b))
+	for _, k := range b {
+		skip[k] = true
+	}
+	var out []string
+	for _, k := range a {
+		if !skip[k] {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// Uploads a new file for an existing image. The id, name, category and
+// description stay; the previous file is kept as a version.
+func replaceImageFile(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	file, _, err := c.Request.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Image file is required"})
+		return
+	}
+	defer file.Close()
+
+	data, decoded, err := readImage(file)
+	if err != nil {
+		respondImageError(c, err)
+		return
+	}
+	clean, err := imaging.Sanitize(data, decoded)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process image"})
+		return
+	}
+	metadata, _ := json.Marshal(clean.Metadata)
+
+	hashes := hashImage(clean)
+	placeholder := imaging.NewPlaceholder(clean.Image)
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	var exists bool
+	err = tx.QueryRow(context.Background(),
+		`SELECT true FROM images
+		WHERE id = $1 AND status = 'active' AND deleted_at IS NULL FOR UPDATE`, imageID,
+	).Scan(&exists)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	duplicate, ok := checkDuplicate(c, tx, hashes, imageID)
+	if !ok {
+		return
+	}
+
+	staleKeys, err := archiveVersion(context.Background(), tx, imageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// A fresh key, the old object now belongs to the archived version
+	ctx := c.Request.Context()
+	objectKey := fmt.Sprintf("images/%s%s", uuid.New().String(), imaging.Extension(decoded.Format))
+	err = store.Put(ctx, objectKey, bytes.NewReader(clean.Data), int64(len(clean.Data)), decoded.MIMEType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage upload failed"})
+		return
+	}
+
+	var version int
+	err = tx.QueryRow(context.Background(),
+		`UPDATE images SET
+			s3_key = $1,
+			content_type = $2,
+			size_bytes = $3,
+			metadata = $4,
+			width = $5,
+			height = $6,
+			sha256 = $7,
+			phash = $8,
+			blurhash = $9,
+			dominant_color = $10,
+			version = version + 1
+		WHERE id = $11
+		RETURNING version`,
+		objectKey, decoded.MIMEType, len(clean.Data), string(metadata),
+		clean.Metadata.Width, clean.Metadata.Height, hashes.SHA256, int64(hashes.PHash),
+		placeholder.BlurHash, placeholder.Color, imageID,
+	).Scan(&version)
+	if err != nil {
+		removeObjects(ctx, []string{objectKey})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	variantKeys, err := generateVariants(ctx, tx, imageID, objectKey, clean.Image, decoded.Format)
+	if err != nil {
+		removeObjects(ctx, append(variantKeys, objectKey))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Variant generation failed"})
+		return
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		removeObjects(ctx, append(variantKeys, objectKey))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+	removeObjects(ctx, staleKeys)
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":           imageID,
+		"s3_key":       objectKey,
+		"version":      version,
+		"message":      "Image file replaced",
+		"duplicate_of": duplicate,
+	})
+}
+
+type imageVersion struct {
+	Version     int       `json:"version"`
+	S3Key       string    `json:"s3_key"`
+	ContentType *string   `json:"content_type"`
+	SizeBytes   *int64    `json:"size_bytes"`
+	Width       *int      `json:"width"`
+	Height      *int      `json:"height"`
+	ArchivedAt  time.Time `json:"archived_at"`
+	Url         string    `json:"url"`
+}
+
+// Lists the earlier files of an image, newest first.
+func getImageVersions(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	var current int
+	err = db.QueryRow(context.Background(),
+		`SELECT version FROM images
+		WHERE id = $1 AND status = 'active' AND deleted_at IS NULL`, imageID,
+	).Scan(&current)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	rows, err := db.Query(context.Background(),
+		`SELECT v.version, v.s3_key, v.content_type, v.size_bytes, v.width, v.height, v.created_at
+		FROM image_versions v
+		JOIN images i ON i.id = v.image_id
+		WHERE v.image_id = $1 AND v.s3_key <> i.s3_key
+		ORDER BY v.version DESC`, imageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer rows.Close()
+
+	versions := []imageVersion{}
+	for rows.Next() {
+		var v imageVersion
+		if err := rows.Scan(&v.Version, &v.S3Key, &v.ContentType, &v.SizeBytes, &v.Width, &v.Height, &v.ArchivedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse data"})
+			return
+		}
+
+		v.Url, err = presigned.PresignGet(c.Request.Context(), v.S3Key, 15*time.Minute)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "URL generation failed for " + v.S3Key})
+			return
+		}
+		versions = append(versions, v)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": imageID, "current_version": current, "versions": versions})
+}
+
+// Makes an archived version the current file again. The file being replaced
+// is archived in turn, so a revert can itself be reverted; the version that
+// became current leaves the history.
+func revertImageVersion(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+	target, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	var currentKey string
+	err = tx.QueryRow(context.Background(),
+		`SELECT s3_key FROM images
+		WHERE id = $1 AND status = 'active' AND deleted_at IS NULL FOR UPDATE`, imageID,
+	).Scan(&currentKey)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	var targetKey string
+	err = tx.QueryRow(context.Background(),
+		`SELECT s3_key FROM image_versions WHERE image_id = $1 AND version = $2`, imageID, target,
+	).Scan(&targetKey)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+	if targetKey == currentKey {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Version is already the current file"})
+		return
+	}
+
+	// Stored files are already stripped and upright, decoding is enough
+	ctx := c.Request.Context()
+	obj, err := store.Get(ctx, targetKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage read failed"})
+		return
+	}
+	_, decoded, err := readImage(obj)
+	obj.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Stored version could not be decoded"})
+		return
+	}
+	placeholder := imaging.NewPlaceholder(decoded.Image)
+
+	staleKeys, err := archiveVersion(context.Background(), tx, imageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var version int
+	err = tx.QueryRow(context.Background(),
+		`UPDATE images i SET
+			s3_key = v.s3_key,
+			content_type = v.content_type,
+			size_bytes = v.size_bytes,
+			metadata = v.metadata,
+			width = v.width,
+			height = v.height,
+			sha256 = v.sha256,
+			phash = v.phash,
+			blurhash = $3,
+			dominant_color = $4,
+			version = i.version + 1
+		FROM image_versions v
+		WHERE i.id = $1 AND v.image_id = i.id AND v.version = $2
+		RETURNING i.version`, imageID, target, placeholder.BlurHash, placeholder.Color,
+	).Scan(&version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// The target is the current file now, not an old version
+	_, err = tx.Exec(context.Background(),
+		`DELETE FROM image_versions WHERE image_id = $1 AND version = $2`, imageID, target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	variantKeys, err := generateVariants(ctx, tx, imageID, targetKey, decoded.Image, decoded.Format)
+	if err != nil {
+		removeObjects(ctx, subtractKeys(variantKeys, staleKeys))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Variant generation failed"})
+		return
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		removeObjects(ctx, subtractKeys(variantKeys, staleKeys))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+	removeObjects(ctx, subtractKeys(staleKeys, variantKeys))
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":            imageID,
+		"s3_key":        targetKey,
+		"version":       version,
+		"reverted_from": target,
+		"message":       "Image reverted",
+	})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// versionHistory returns what getImageVersions reports for id.
+func versionHistory(t *testing.T, id int) (int, []imageVersion) {
+	t.Helper()
+	w := call(getImageVersions, httptest.NewRequest(http.MethodGet, "/", nil),
+		gin.Params{{Key: "id", Value: strconv.Itoa(id)}}, "tester", RoleViewer)
+	if w.Code != http.StatusOK {
+		t.Fatalf("versions: status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		CurrentVersion int            `json:"current_version"`
+		Versions       []imageVersion `json:"versions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.CurrentVersion, resp.Versions
+}
+
+// currentFile returns the version and key of the file id shows now.
+func currentFile(t *testing.T, id int) (int, string) {
+	t.Helper()
+	var (
+		version int
+		key     string
+	)
+	err := db.QueryRow(context.Background(), `SELECT version, s3_key FROM images WHERE id = $1`, id).Scan(&version, &key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version, key
+}
+
+// checkHistory compares the versions and keys of the archived files with
+// want, newest first, and checks their objects are still stored.
+func checkHistory(t *testing.T, id int, want []imageVersion) {
+	t.Helper()
+	_, versions := versionHistory(t, id)
+	if len(versions) != len(want) {
+		t.Fatalf("history %+v, want %v", versions, want)
+	}
+	for i, v := range versions {
+		if v.Version != want[i].Version || v.S3Key != want[i].S3Key {
+			t.Errorf("history[%d] = %d %s, want %d %s", i, v.Version, v.S3Key, want[i].Version, want[i].S3Key)
+		}
+		if _, err := store.Stat(context.Background(), v.S3Key); err != nil {
+			t.Errorf("archived version %d: %v", v.Version, err)
+		}
+	}
+}
+
+func TestReplaceAndRevertImage(t *testing.T) {
+	needDB(t)
+	t.Setenv("DIMAS_DUPLICATE_POLICY", "flag")
+	category := testCategory(t)
+	id, key1 := testActiveImage(t, category, 1)
+	params := gin.Params{{Key: "id", Value: strconv.Itoa(id)}}
+
+	replace := func(seed int) string {
+		t.Helper()
+		req := multipartRequest(t, "/", nil, testPNG(t, seed))
+		w := call(replaceImageFile, req, params, "tester", RoleEditor)
+		if w.Code != http.StatusOK {
+			t.Fatalf("replace: status %d: %s", w.Code, w.Body)
+		}
+		_, key := currentFile(t, id)
+		return key
+	}
+	revert := func(version int) *httptest.ResponseRecorder {
+		t.Helper()
+		return call(revertImageVersion, httptest.NewRequest(http.MethodPost, "/", nil),
+			append(params, gin.Param{Key: "version", Value: strconv.Itoa(version)}), "tester", RoleEditor)
+	}
+
+	key2 := replace(2)
+	key3 := replace(3)
+	if current, _ := versionHistory(t, id); current != 3 {
+		t.Fatalf("current version %d after two replacements, want 3", current)
+	}
+	checkHistory(t, id, []imageVersion{{Version: 2, S3Key: key2}, {Version: 1, S3Key: key1}})
+
+	// Reverting archives the current file and takes the target out of the history
+	if w := revert(1); w.Code != http.StatusOK {
+		t.Fatalf("revert: status %d: %s", w.Code, w.Body)
+	}
+	if version, key := currentFile(t, id); version != 4 || key != key1 {
+		t.Errorf("after revert: version %d key %s, want 4 %s", version, key, key1)
+	}
+	checkHistory(t, id, []imageVersion{{Version: 3, S3Key: key3}, {Version: 2, S3Key: key2}})
+
+	var variants int
+	db.QueryRow(context.Background(), `SELECT count(*) FROM image_variants WHERE image_id = $1 AND NOT watermarked`, id).Scan(&variants)
+	if variants == 0 {
+		t.Error("no variants rendered for the reverted file")
+	}
+
+	// The revert can itself be reverted
+	if w := revert(3); w.Code != http.StatusOK {
+		t.Fatalf("second revert: status %d: %s", w.Code, w.Body)
+	}
+	if version, key := currentFile(t, id); version != 5 || key != key3 {
+		t.Errorf("after second revert: version %d key %s, want 5 %s", version, key, key3)
+	}
+	checkHistory(t, id, []imageVersion{{Version: 4, S3Key: key1}, {Version: 2, S3Key: key2}})
+
+	if w := revert(3); w.Code != http.StatusNotFound {
+		t.Errorf("revert to a version no longer archived: status %d, want 404", w.Code)
+	}
+}
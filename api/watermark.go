@@ -0,0 +1,581 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"golang-api/imaging"
+)
+
+// watermarkApplies is true for an image i in category c whose public copies
+// carry the watermark w. Queries using it join watermark_settings as w.
+const watermarkApplies = `(w.enabled AND COALESCE(i.watermark, c.watermark)
+	AND (w.text <> '' OR w.overlay_key IS NOT NULL))`
+
+// watermarkStale is true for images whose watermarked derivatives don't
+// match the current settings and need reprocessing.
+const watermarkStale = `(CASE WHEN ` + watermarkApplies + `
+	THEN i.watermark_version IS DISTINCT FROM w.version
+	ELSE i.watermark_version IS NOT NULL END)`
+
+// watermarkUnrendered is true for images the watermark applies to that have
+// no watermarked derivatives at all, stale or not. They are left out of
+// public delivery until rendered.
+const watermarkUnrendered = `(` + watermarkApplies + ` AND NOT EXISTS (
+	SELECT 1 FROM image_variants v WHERE v.image_id = i.id AND v.watermarked))`
+
+// renderBatch is how many images a settings change renders before responding.
+const renderBatch = 20
+
+// watermarkFor returns the watermark the public copies of imageID get and the
+// settings version it was built from, or nil if none applies.
+func watermarkFor(ctx context.Context, q dbConn, imageID int) (*imaging.Watermark, int, error) {
+	var (
+		applies    bool
+		version    int
+		overlayKey *string
+		wm         imaging.Watermark
+		opacity    float32
+		scale      float32
+	)
+	err := q.QueryRow(ctx,
+		`SELECT `+watermarkApplies+`, w.version, w.text, w.overlay_key, w.position, w.opacity, w.scale
+		FROM images i
+		JOIN categories c ON c.id = i.category_id
+		CROSS JOIN watermark_settings w
+		WHERE i.id = $1`, imageID,
+	).Scan(&applies, &version, &wm.Text, &overlayKey, &wm.Position, &opacity, &scale)
+	if err != nil || !applies {
+		return nil, 0, err
+	}
+	wm.Opacity, wm.Scale = float64(opacity), float64(scale)
+
+	if overlayKey != nil {
+		wm.Overlay, err = loadOverlay(ctx, *overlayKey)
+		if err != nil {
+			return nil, 0, fmt.Errorf("watermark overlay: %w", err)
+		}
+	}
+	return &wm, version, nil
+}
+
+// The overlay only changes along with its key, so the last one decoded is
+// kept around instead of being fetched for every image.
+var overlayCache struct {
+	sync.Mutex
+	key string
+	img image.Image
+}
+
+func loadOverlay(ctx context.Context, key string) (image.Image, error) {
+	overlayCache.Lock()
+	defer overlayCache.Unlock()
+	if overlayCache.key == key {
+		return overlayCache.img, nil
+	}
+
+	obj, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	_, decoded, err := readImage(obj)
+	if err != nil {
+		return nil, err
+	}
+	overlayCache.key, overlayCache.img = key, decoded.Image
+	return decoded.Image, nil
+}
+
+// storeWatermarked renders and stores the watermarked derivatives of img
+// when wm is set, and records which settings version the image now carries.
+func storeWatermarked(ctx context.Context, q dbConn, imageID int, s3Key string, img image.Image, srcFormat string, wm *imaging.Watermark, version int) ([]string, error) {
+	if wm == nil {
+		_, err := q.Exec(ctx, `UPDATE images SET watermark_version = NULL WHERE id = $1`, imageID)
+		return nil, err
+	}
+
+	renditions, err := imaging.Render(img, imaging.BaseFormat(img, srcFormat), wm)
+	if err != nil {
+		return nil, err
+	}
+	written, err := storeRenditions(ctx, q, imageID, s3Key, renditions, true)
+	if err != nil {
+		return written, err
+	}
+	_, err = q.Exec(ctx, `UPDATE images SET watermark_version = $1 WHERE id = $2`, version, imageID)
+	return written, err
+}
+
+var errImageNotFound = errors.New("image not found")
+
+// reprocessWatermark brings the watermarked derivatives of one image in line
+// with the current settings and its category/image switches.
+func reprocessWatermark(ctx context.Context, imageID int) error {
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	var s3Key string
+	err = tx.QueryRow(context.Background(),
+		`SELECT s3_key FROM images
+		WHERE id = $1 AND status = 'active' AND deleted_at IS NULL FOR UPDATE`, imageID,
+	).Scan(&s3Key)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return errImageNotFound
+		}
+		return err
+	}
+
+	var oldKeys []string
+	rows, err := tx.Query(context.Background(),
+		`DELETE FROM image_variants WHERE image_id = $1 AND watermarked RETURNING s3_key`, imageID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		oldKeys = append(oldKeys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	wm, version, err := watermarkFor(context.Background(), tx, imageID)
+	if err != nil {
+		return err
+	}
+
+	// Only decode the original when there is something to draw on it
+	var (
+		img    image.Image
+		format string
+	)
+	if wm != nil {
+		obj, err := store.Get(ctx, s3Key)
+		if err != nil {
+			return err
+		}
+		_, decoded, err := readImage(obj)
+		obj.Close()
+		if err != nil {
+			return err
+		}
+		img, format = decoded.Image, decoded.Format
+	}
+	written, err := storeWatermarked(ctx, tx, imageID, s3Key, img, format, wm, version)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return err
+	}
+	removeObjects(ctx, subtractKeys(oldKeys, written))
+	return nil
+}
+
+// countStaleWatermarks returns how many images still need reprocessing and
+// how many of those are hidden because they have no watermarked copy yet.
+func countStaleWatermarks() (pending, hidden int, err error) {
+	err = db.QueryRow(context.Background(),
+		`SELECT COUNT(*), COUNT(*) FILTER (WHERE `+watermarkUnrendered+`)
+		FROM images i
+		JOIN categories c ON c.id = i.category_id
+		CROSS JOIN watermark_settings w
+		WHERE i.status = 'active' AND i.deleted_at IS NULL AND `+watermarkStale).Scan(&pending, &hidden)
+	return pending, hidden, err
+}
+
+// reprocessStaleWatermarks re-renders up to limit stale images, those with no
+// watermarked copy at all first, and reports the ones that failed.
+func reprocessStaleWatermarks(ctx context.Context, limit int) (int, []gin.H, error) {
+	rows, err := db.Query(context.Background(),
+		`SELECT i.id FROM images i
+		JOIN categories c ON c.id = i.category_id
+		CROSS JOIN watermark_settings w
+		WHERE i.status = 'active' AND i.deleted_at IS NULL AND `+watermarkStale+`
+		ORDER BY `+watermarkUnrendered+` DESC, i.id
+		LIMIT $1`, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	processed := 0
+	failed := []gin.H{}
+	for _, id := range ids {
+		if err := reprocessWatermark(ctx, id); err != nil && !errors.Is(err, errImageNotFound) {
+			failed = append(failed, gin.H{"id": id, "error": err.Error()})
+			continue
+		}
+		processed++
+	}
+	return processed, failed, nil
+}
+
+// renderAfterChange renders the first batch of images a settings change made
+// stale, so turning the watermark on doesn't hide every image until the
+// next POST /watermark/reprocess. It returns false once an error response
+// has been written.
+func renderAfterChange(c *gin.Context) bool {
+	if _, _, err := reprocessStaleWatermarks(c.Request.Context(), renderBatch); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return false
+	}
+	return true
+}
+
+// respondWatermarkSettings writes the settings along with the number of
+// images waiting to be reprocessed. Until then a stale image keeps serving
+// its previous watermarked copy, and one that never had a watermarked copy
+// ("hidden") is left out of public and shared delivery instead of showing
+// the clean original.
+func respondWatermarkSettings(c *gin.Context) {
+	var (
+		enabled        bool
+		text, position string
+		overlayKey     *string
+		opacity, scale float32
+		version        int
+		updatedAt      time.Time
+	)
+	err := db.QueryRow(context.Background(),
+		`SELECT enabled, text, overlay_key, position, opacity, scale, version, updated_at
+		FROM watermark_settings WHERE id = 1`,
+	).Scan(&enabled, &text, &overlayKey, &position, &opacity, &scale, &version, &updatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	pending, hidden, err := countStaleWatermarks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	overlayURL := ""
+	if overlayKey != nil {
+		overlayURL, err = presigned.PresignGet(c.Request.Context(), *overlayKey, 15*time.Minute)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "URL generation failed"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":     enabled,
+		"text":        text,
+		"overlay_url": overlayURL,
+		"position":    position,
+		"opacity":     opacity,
+		"scale":       scale,
+		"version":     version,
+		"updated_at":  updatedAt,
+		"pending":     pending,
+		"hidden":      hidden,
+	})
+}
+
+func getWatermark(c *gin.Context) {
+	respondWatermarkSettings(c)
+}
+
+// Changes the watermark settings. Fields left out keep their value. The first
+// images are re-rendered right away; "pending" in the response tells how
+// many still wait for POST /watermark/reprocess.
+func updateWatermark(c *gin.Context) {
+	var input struct {
+		Enabled  *bool    `json:"enabled"`
+		Text     *string  `json:"text"`
+		Position *string  `json:"position"`
+		Opacity  *float64 `json:"opacity"`
+		Scale    *float64 `json:"scale"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if input.Position != nil && !imaging.ValidPosition(*input.Position) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position must be top-left, top-right, bottom-left, bottom-right or center"})
+		return
+	}
+	if input.Opacity != nil && (*input.Opacity <= 0 || *input.Opacity > 1) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "opacity must be above 0 and at most 1"})
+		return
+	}
+	if input.Scale != nil && (*input.Scale <= 0 || *input.Scale > 1) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scale must be above 0 and at most 1"})
+		return
+	}
+
+	_, err := db.Exec(context.Background(),
+		`UPDATE watermark_settings SET
+			enabled = COALESCE($1, enabled),
+			text = COALESCE($2, text),
+			position = COALESCE($3, position),
+			opacity = COALESCE($4, opacity),
+			scale = COALESCE($5, scale),
+			version = version + 1,
+			updated_at = now()
+		WHERE id = 1`,
+		input.Enabled, input.Text, input.Position, input.Opacity, input.Scale,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+	if !renderAfterChange(c) {
+		return
+	}
+
+	respondWatermarkSettings(c)
+}
+
+// Sets the image overlay, which takes precedence over the text. A PNG with
+// transparency works best.
+func uploadWatermarkOverlay(c *gin.Context) {
+	file, _, err := c.Request.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Image file is required"})
+		return
+	}
+	defer file.Close()
+
+	data, decoded, err := readImage(file)
+	if err != nil {
+		respondImageError(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := fmt.Sprintf("watermarks/%s%s", uuid.New().String(), imaging.Extension(decoded.Format))
+	if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), decoded.MIMEType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage upload failed"})
+		return
+	}
+
+	if !setOverlayKey(c, &key) {
+		removeObjects(ctx, []string{key})
+		return
+	}
+	if !renderAfterChange(c) {
+		return
+	}
+	respondWatermarkSettings(c)
+}
+
+// Removes the image overlay; the text watermark, if any, is used again.
+func deleteWatermarkOverlay(c *gin.Context) {
+	if !setOverlayKey(c, nil) || !renderAfterChange(c) {
+		return
+	}
+	respondWatermarkSettings(c)
+}
+
+// setOverlayKey swaps the overlay and deletes the previous one from storage.
+// It returns false once an error response has been written.
+func setOverlayKey(c *gin.Context, key *string) bool {
+	var oldKey *string
+	err := db.QueryRow(context.Background(),
+		`UPDATE watermark_settings w SET
+			overlay_key = $1,
+			version = w.version + 1,
+			updated_at = now()
+		FROM watermark_settings old
+		WHERE w.id = 1 AND old.id = 1
+		RETURNING old.overlay_key`, key,
+	).Scan(&oldKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return false
+	}
+	if oldKey != nil {
+		removeObjects(c.Request.Context(), []string{*oldKey})
+	}
+	return true
+}
+
+// Re-renders the watermarked derivatives of up to ?limit= (default 20)
+// images that don't match the current settings. Call it again while
+// "remaining" is above zero.
+func reprocessWatermarks(c *gin.Context) {
+	limit := 20
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+
+	processed, failed, err := reprocessStaleWatermarks(c.Request.Context(), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+
+	remaining, hidden, err := countStaleWatermarks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"processed": processed, "failed": failed, "remaining": remaining, "hidden": hidden})
+}
+
+// Overrides the watermark for one image: true or false, or null to follow
+// its category. The image is reprocessed right away.
+func setImageWatermark(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+	var input struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	tag, err := db.Exec(context.Background(),
+		`UPDATE images SET watermark = $1
+		WHERE id = $2 AND status = 'active' AND deleted_at IS NULL`, input.Enabled, imageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	if err := reprocessWatermark(c.Request.Context(), imageID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Watermark rendering failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": imageID, "watermark": input.Enabled, "message": "Watermark updated"})
+}
+
+// Turns the watermark on or off for a whole category. The first of its images
+// are re-rendered right away, the rest are left for POST /watermark/reprocess.
+func setCategoryWatermark(c *gin.Context) {
+	categoryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+	var input struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	tag, err := db.Exec(context.Background(),
+		`UPDATE categories SET watermark = $1 WHERE id = $2`, *input.Enabled, categoryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	if !renderAfterChange(c) {
+		return
+	}
+
+	pending, hidden, err := countStaleWatermarks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": categoryID, "watermark": *input.Enabled, "pending": pending, "hidden": hidden})
+}
+
+// publicImageURLs returns, per image id, the full-size URL for public and
+// shared delivery: the original, or the largest watermarked derivative when
+// the watermark applies, which may still be from earlier settings until the
+// image is reprocessed. Images that have no watermarked copy yet, like
+// trashed ones, are missing from the map; callers leave them out rather than
+// show the clean original.
+func publicImageURLs(ctx context.Context, imageIDs []int) (map[int]string, error) {
+	result := make(map[int]string, len(imageIDs))
+	if len(imageIDs) == 0 {
+		return result, nil
+	}
+
+	rows, err := db.Query(context.Background(),
+		`SELECT i.id, CASE WHEN `+watermarkApplies+` THEN v.s3_key ELSE i.s3_key END
+		FROM images i
+		JOIN categories c ON c.id = i.category_id
+		CROSS JOIN watermark_settings w
+		LEFT JOIN image_variants v ON v.image_id = i.id AND v.watermarked
+			AND v.name = $2 AND v.format <> 'webp'
+		WHERE i.id = ANY($1) AND i.status = 'active' AND i.deleted_at IS NULL`, imageIDs, imaging.Variants[len(imaging.Variants)-1].Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keyByID := map[int]string{}
+	var keys []string
+	for rows.Next() {
+		var (
+			id  int
+			key *string
+		)
+		if err := rows.Scan(&id, &key); err != nil {
+			return nil, err
+		}
+		if key != nil {
+			keyByID[id] = *key
+			keys = append(keys, *key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	urls, err := presigned.PresignGetMany(ctx, keys, 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	for id, key := range keyByID {
+		result[id] = urls[key]
+	}
+	return result, nil
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// withWatermark turns the text watermark on (or off) for the test and puts
+// the previous settings back afterwards.
+func withWatermark(t *testing.T, enabled bool) {
+	t.Helper()
+	ctx := context.Background()
+	var (
+		was  bool
+		text string
+	)
+	if err := db.QueryRow(ctx, `SELECT enabled, text FROM watermark_settings WHERE id = 1`).Scan(&was, &text); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(ctx, `UPDATE watermark_settings SET enabled = $1, text = $2, version = version + 1 WHERE id = 1`, was, text)
+	})
+	_, err := db.Exec(ctx, `UPDATE watermark_settings SET enabled = $1, text = 'test', version = version + 1 WHERE id = 1`, enabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// publicURL returns the public URL of one image and whether it has one.
+func publicURL(t *testing.T, id int) (string, bool) {
+	t.Helper()
+	urls, err := publicImageURLs(context.Background(), []int{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, ok := urls[id]
+	return url, ok
+}
+
+func jsonRequest(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestPublicImageURLsNotRenderedYet(t *testing.T) {
+	needDB(t)
+	ctx := context.Background()
+	category := testCategory(t)
+	id, key := testActiveImage(t, category, 1)
+
+	withWatermark(t, false)
+	if url, ok := publicURL(t, id); !ok || !strings.Contains(url, key) {
+		t.Fatalf("watermark off: got %q, want the original", url)
+	}
+
+	// Watermark on, nothing rendered yet: hidden, never the clean original
+	withWatermark(t, true)
+	if url, ok := publicURL(t, id); ok {
+		t.Fatalf("not rendered yet: got %q, want no URL", url)
+	}
+	images, err := sharedImages(ctx, `i.id = $1`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 0 {
+		t.Errorf("shared images %v, want the unrendered image left out", images)
+	}
+	if _, hidden, err := countStaleWatermarks(); err != nil || hidden == 0 {
+		t.Errorf("hidden = %d (%v), want the unrendered image counted", hidden, err)
+	}
+
+	if err := reprocessWatermark(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	rendered, ok := publicURL(t, id)
+	if !ok || strings.Contains(rendered, key) {
+		t.Fatalf("rendered: got %q, want a watermarked copy", rendered)
+	}
+
+	// New settings make it stale; the previous render is served until then
+	db.Exec(ctx, `UPDATE watermark_settings SET text = 'changed', version = version + 1 WHERE id = 1`)
+	if url, ok := publicURL(t, id); !ok || url != rendered {
+		t.Errorf("stale: got %q, want the previous render %q", url, rendered)
+	}
+}
+
+func TestSetCategoryWatermark(t *testing.T) {
+	needDB(t)
+	category := testCategory(t)
+	id, key := testActiveImage(t, category, 2)
+	withWatermark(t, true)
+	params := gin.Params{{Key: "id", Value: strconv.Itoa(category)}}
+
+	w := call(setCategoryWatermark, jsonRequest(http.MethodPut, "/", `{"enabled": false}`), params, "owner", RoleOwner)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if url, ok := publicURL(t, id); !ok || !strings.Contains(url, key) {
+		t.Fatalf("category off: got %q, want the original", url)
+	}
+
+	// Turning it on renders the image before responding
+	w = call(setCategoryWatermark, jsonRequest(http.MethodPut, "/", `{"enabled": true}`), params, "owner", RoleOwner)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if url, ok := publicURL(t, id); !ok || strings.Contains(url, key) {
+		t.Errorf("category on: got %q, want a watermarked copy", url)
+	}
+
+	w = call(setCategoryWatermark, jsonRequest(http.MethodPut, "/", `{"enabled": true}`), gin.Params{{Key: "id", Value: "0"}}, "owner", RoleOwner)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing category: status %d, want 404", w.Code)
+	}
+}
+
+func TestSetImageWatermark(t *testing.T) {
+	needDB(t)
+	category := testCategory(t)
+	id, key := testActiveImage(t, category, 3)
+	withWatermark(t, true)
+	params := gin.Params{{Key: "id", Value: strconv.Itoa(id)}}
+
+	for _, tc := range []struct {
+		body        string
+		watermarked bool
+	}{
+		{`{"enabled": false}`, false},
+		{`{"enabled": null}`, true}, // follows the category, which is on
+		{`{"enabled": true}`, true},
+	} {
+		w := call(setImageWatermark, jsonRequest(http.MethodPut, "/", tc.body), params, "owner", RoleOwner)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tc.body, w.Code, w.Body)
+		}
+		url, ok := publicURL(t, id)
+		if !ok || strings.Contains(url, key) == tc.watermarked {
+			t.Errorf("%s: got %q, want watermarked %v", tc.body, url, tc.watermarked)
+		}
+	}
+
+	db.Exec(context.Background(), `UPDATE categories SET watermark = false WHERE id = $1`, category)
+	w := call(setImageWatermark, jsonRequest(http.MethodPut, "/", `{"enabled": true}`), params, "owner", RoleOwner)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if url, ok := publicURL(t, id); !ok || strings.Contains(url, key) {
+		t.Errorf("image on in a category that is off: got %q, want a watermarked copy", url)
+	}
+}
@@ -0,0 +1,257 @@
+// Command porto runs maintenance jobs against the portfolio database and
+// object storage outside of the API. It reads the same environment
+// variables as the API (STORAGE_DATABASE_URL, DIMAS_STORAGE_BACKEND, ...).
+//
+//	porto reconcile [-fix] [-grace 1h]
+//	porto expire-uploads
+//	porto purge-trash [-days 30]
+//	porto migrate-storage [-name default] [-rewrite old/=new/] [-workers 4] [-rewrite-keys]
+//	porto backfill-placeholders [-batch 50]
+//	porto set-role <username> <owner|editor|viewer>
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"golang-api/maintenance"
+	"golang-api/storage"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: porto <command> [flags]
+
+commands:
+  reconcile       report (or with -fix, remove) orphaned objects and dangling rows
+  expire-uploads  remove pending and resumable uploads that were never completed
+  purge-trash     permanently delete images trashed longer than the retention period
+  migrate-storage copy every stored object to another backend and verify it
+  backfill-placeholders
+                  compute BlurHash and dominant color of older images
+  set-role        change the role of an account, e.g. to promote the first owner`)
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	ctx := context.Background()
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "reconcile":
+		runReconcile(ctx, args)
+	case "expire-uploads":
+		db, store := connect(ctx)
+		defer db.Close()
+		n, err := maintenance.ExpirePendingUploads(ctx, db, store)
+		if err != nil {
+			log.Fatalf("expire-uploads: %v", err)
+		}
+		fmt.Printf("expired %d pending uploads\n", n)
+		n, err = maintenance.ExpireTusUploads(ctx, db, store)
+		if err != nil {
+			log.Fatalf("expire-uploads: %v", err)
+		}
+		fmt.Printf("expired %d resumable uploads\n", n)
+	case "purge-trash":
+		runPurgeTrash(ctx, args)
+	case "migrate-storage":
+		runMigrateStorage(ctx, args)
+	case "backfill-placeholders":
+		runBackfillPlaceholders(ctx, args)
+	case "set-role":
+		runSetRole(ctx, args)
+	default:
+		usage()
+	}
+}
+
+func connect(ctx context.Context) (*pgxpool.Pool, storage.Storage) {
+	databaseUrl := os.Getenv("STORAGE_DATABASE_URL")
+	if databaseUrl == "" {
+		log.Fatal("STORAGE_DATABASE_URL is not set")
+	}
+	db, err := pgxpool.Connect(ctx, databaseUrl)
+	if err != nil {
+		log.Fatalf("Unable to connect to database: %v", err)
+	}
+	store, err := storage.FromEnv(ctx)
+	if err != nil {
+		log.Fatalf("Storage config error: %v", err)
+	}
+	return db, store
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func runReconcile(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	fix := fs.Bool("fix", false, "delete orphaned objects and dangling rows")
+	grace := fs.Duration("grace", time.Hour, "ignore objects modified more recently than this")
+	fs.Parse(args)
+
+	db, store := connect(ctx)
+	defer db.Close()
+
+	report, err := maintenance.Reconcile(ctx, db, store, maintenance.ReconcileOptions{Fix: *fix, Grace: *grace})
+	if err != nil {
+		log.Fatalf("reconcile: %v", err)
+	}
+	printJSON(report)
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+func runPurgeTrash(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("purge-trash", flag.ExitOnError)
+	defaultDays, err := strconv.Atoi(os.Getenv("DIMAS_TRASH_RETENTION_DAYS"))
+	if err != nil {
+		defaultDays = 30
+	}
+	days := fs.Int("days", defaultDays, "retention period in days (DIMAS_TRASH_RETENTION_DAYS)")
+	fs.Parse(args)
+
+	db, store := connect(ctx)
+	defer db.Close()
+
+	n, err := maintenance.PurgeTrash(ctx, db, store, time.Duration(*days)*24*time.Hour)
+	fmt.Printf("purged %d images\n", n)
+	if err != nil {
+		log.Fatalf("purge-trash: %v", err)
+	}
+}
+
+// migrateEnv reads the destination of migrate-storage from the storage
+// variables with DIMAS_ replaced by DIMAS_MIGRATE_, e.g.
+// DIMAS_MIGRATE_STORAGE_BACKEND and DIMAS_MIGRATE_S3_BUCKET.
+func migrateEnv(name string) string {
+	return os.Getenv("DIMAS_MIGRATE_" + strings.TrimPrefix(name, "DIMAS_"))
+}
+
+// runMigrateStorage copies from the configured backend to the DIMAS_MIGRATE_
+// one. Run it while the API stays up until nothing fails (verified objects
+// are skipped), then stop uploads, run it a last time (with -rewrite-keys
+// when keys are renamed) and point the API at the new backend.
+func runMigrateStorage(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("migrate-storage", flag.ExitOnError)
+	name := fs.String("name", "default", "name progress is recorded under; reuse it to resume")
+	rewrite := fs.String("rewrite", "", "rename keys with one prefix to another, as old/=new/")
+	workers := fs.Int("workers", 4, "objects copied at once")
+	rewriteKeys := fs.Bool("rewrite-keys", false, "after a complete copy, update the keys in the database")
+	fs.Parse(args)
+
+	opts := maintenance.MigrateOptions{Name: *name, Workers: *workers}
+	if *rewrite != "" {
+		var ok bool
+		opts.FromPrefix, opts.ToPrefix, ok = strings.Cut(*rewrite, "=")
+		if !ok {
+			log.Fatal("migrate-storage: -rewrite must look like old/=new/")
+		}
+	}
+
+	if migrateEnv("DIMAS_STORAGE_BACKEND") == "" {
+		log.Fatal("migrate-storage: DIMAS_MIGRATE_STORAGE_BACKEND is not set")
+	}
+	db, src := connect(ctx)
+	defer db.Close()
+	dst, err := storage.FromLookup(ctx, migrateEnv)
+	if err != nil {
+		log.Fatalf("Destination storage config error: %v", err)
+	}
+
+	report, err := maintenance.MigrateObjects(ctx, db, src, dst, opts)
+	if err != nil {
+		log.Fatalf("migrate-storage: %v", err)
+	}
+	printJSON(report)
+	if len(report.Failed) > 0 {
+		if *rewriteKeys {
+			log.Print("migrate-storage: keys not rewritten, some objects failed")
+		}
+		os.Exit(1)
+	}
+
+	if *rewriteKeys {
+		n, err := maintenance.RewriteKeys(ctx, db, *name)
+		if err != nil {
+			log.Fatalf("migrate-storage: %v", err)
+		}
+		fmt.Printf("rewrote %d keys\n", n)
+	}
+}
+
+func runBackfillPlaceholders(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("backfill-placeholders", flag.ExitOnError)
+	batch := fs.Int("batch", 50, "images per batch")
+	fs.Parse(args)
+
+	db, store := connect(ctx)
+	defer db.Close()
+
+	processed, failed, afterID := 0, 0, 0
+	for {
+		report, err := maintenance.BackfillPlaceholders(ctx, db, store, afterID, *batch)
+		if err != nil {
+			log.Fatalf("backfill-placeholders: %v", err)
+		}
+		for _, f := range report.Failed {
+			log.Printf("image %d: %s", f.ID, f.Error)
+		}
+		processed += report.Processed
+		failed += len(report.Failed)
+		if report.Remaining == 0 || report.NextAfterID == afterID {
+			break
+		}
+		afterID = report.NextAfterID
+	}
+	fmt.Printf("computed %d placeholders, %d failed\n", processed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func runSetRole(ctx context.Context, args []string) {
+	if len(args) != 2 {
+		log.Fatal("usage: porto set-role <username> <owner|editor|viewer>")
+	}
+	username, role := args[0], args[1]
+	switch role {
+	case "owner", "editor", "viewer":
+	default:
+		log.Fatalf("set-role: unknown role %q", role)
+	}
+
+	db, _ := connect(ctx)
+	defer db.Close()
+
+	tag, err := db.Exec(ctx, `UPDATE users SET role = $1 WHERE username = $2`, role, username)
+	if err != nil {
+		log.Fatalf("set-role: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		log.Fatalf("set-role: no user %q", username)
+	}
+	// Tokens issued before carry the old role
+	if _, err := db.Exec(ctx,
+		`UPDATE sessions SET revoked_at = now() WHERE username = $1 AND revoked_at IS NULL`, username); err != nil {
+		log.Fatalf("set-role: %v", err)
+	}
+	fmt.Printf("%s is now %s\n", username, role)
+}
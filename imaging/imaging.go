@@ -0,0 +1,130 @@
+// Package imaging decodes uploaded images and renders the resized
+// derivatives that are served to the frontend instead of the originals.
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Variant is a named derivative size. MaxSize bounds the longer side.
+type Variant struct {
+	Name    string
+	MaxSize int
+}
+
+// Variants lists the derivatives generated for every image, smallest first.
+var Variants = []Variant{
+	{Name: "thumb", MaxSize: 320},
+	{Name: "medium", MaxSize: 960},
+	{Name: "large", MaxSize: 1920},
+}
+
+// Rendition is one encoded derivative.
+type Rendition struct {
+	Variant string
+	Format  string
+	Width   int
+	Height  int
+	Data    []byte
+}
+
+// Resize scales img down so that neither side exceeds maxSize, keeping the
+// aspect ratio. Images that already fit are returned as they are.
+func Resize(img image.Image, maxSize int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSize && h <= maxSize {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*maxSize/w)
+		w = maxSize
+	} else {
+		w = max(1, w*maxSize/h)
+		h = maxSize
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// BaseFormat picks the format derivatives of an image decoded as srcFormat
+// are stored in next to their WebP copies. JPEG stays JPEG; anything with
+// transparency stays PNG so the alpha channel survives.
+func BaseFormat(img image.Image, srcFormat string) string {
+	switch srcFormat {
+	case "jpeg":
+		return "jpeg"
+	case "png":
+		return "png"
+	}
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return "jpeg"
+	}
+	return "png"
+}
+
+// Encode writes img to w in format ("jpeg", "png" or "webp").
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case "png":
+		return png.Encode(w, img)
+	case "webp":
+		return nativewebp.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("imaging: unsupported format %q", format)
+	}
+}
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Extension returns the file extension, including the dot, used for format.
+func Extension(format string) string {
+	if format == "jpeg" {
+		return ".jpg"
+	}
+	return "." + format
+}
+
+// Render produces every entry of Variants in baseFormat and in WebP. A
+// non-nil wm is drawn onto each one after resizing, so it keeps the same
+// proportions at every size.
+func Render(img image.Image, baseFormat string, wm *Watermark) ([]Rendition, error) {
+	var out []Rendition
+	for _, v := range Variants {
+		resized := Resize(img, v.MaxSize)
+		if wm != nil {
+			resized = ApplyWatermark(resized, wm)
+		}
+		b := resized.Bounds()
+		for _, format := range []string{baseFormat, "webp"} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, resized, format); err != nil {
+				return nil, fmt.Errorf("imaging: encode %s %s: %w", v.Name, format, err)
+			}
+			out = append(out, Rendition{
+				Variant: v.Name,
+				Format:  format,
+				Width:   b.Dx(),
+				Height:  b.Dy(),
+				Data:    buf.Bytes(),
+			})
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,272 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Metadata is what we keep from an image's EXIF block. It is stored in the
+// images.metadata column; the stored object itself is stripped of EXIF.
+type Metadata struct {
+	Width       int        `json:"width"`
+	Height      int        `json:"height"`
+	Orientation int        `json:"orientation,omitempty"`
+	CameraMake  string     `json:"camera_make,omitempty"`
+	CameraModel string     `json:"camera_model,omitempty"`
+	LensModel   string     `json:"lens_model,omitempty"`
+	TakenAt     *time.Time `json:"taken_at,omitempty"`
+	GPS         *GPS       `json:"gps,omitempty"`
+}
+
+// GPS is the location an image was taken at.
+type GPS struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Sanitized is an image ready to be stored and served publicly.
+type Sanitized struct {
+	// Data holds the bytes to store: the upload without any EXIF, XMP or
+	// text metadata, re-encoded only when its orientation had to be applied.
+	Data []byte
+	// Image is the decoded image rotated to orientation 1.
+	Image    image.Image
+	Metadata Metadata
+}
+
+// Sanitize extracts the metadata of a validated upload and strips it from
+// the bytes. Images with an EXIF orientation other than 1 are rotated and
+// re-encoded, since dropping the tag would otherwise display them sideways;
+// so are files whose container could not be stripped.
+func Sanitize(data []byte, d *Decoded) (*Sanitized, error) {
+	meta := ExtractMetadata(data)
+	img := Orient(d.Image, meta.Orientation)
+	b := img.Bounds()
+	meta.Width, meta.Height = b.Dx(), b.Dy()
+
+	if meta.Orientation <= 1 {
+		if clean, err := StripMetadata(data, d.Format); err == nil {
+			return &Sanitized{Data: clean, Image: img, Metadata: meta}, nil
+		}
+		// Containers we can't walk safely get re-encoded instead
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, d.Format); err != nil {
+		return nil, err
+	}
+	return &Sanitized{Data: buf.Bytes(), Image: img, Metadata: meta}, nil
+}
+
+// ExtractMetadata reads the EXIF block of a JPEG, PNG or WebP file. Missing
+// or unreadable EXIF simply yields empty metadata.
+func ExtractMetadata(data []byte) Metadata {
+	var meta Metadata
+
+	raw := exifBlock(data)
+	if raw == nil {
+		return meta
+	}
+	x, err := exif.Decode(bytes.NewReader(raw))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return meta
+	}
+
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if v, err := tag.Int(0); err == nil && v >= 1 && v <= 8 {
+			meta.Orientation = v
+		}
+	}
+	meta.CameraMake = exifString(x, exif.Make)
+	meta.CameraModel = exifString(x, exif.Model)
+	meta.LensModel = exifString(x, exif.LensModel)
+	if t, err := x.DateTime(); err == nil {
+		meta.TakenAt = &t
+	}
+	if lat, lng, err := x.LatLong(); err == nil {
+		meta.GPS = &GPS{Latitude: lat, Longitude: lng}
+	}
+	return meta
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+// exifBlock locates the EXIF payload in the container. JPEG files are handed
+// to goexif as they are since it understands APP1 segments itself.
+func exifBlock(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return data
+	case bytes.HasPrefix(data, pngSignature):
+		var block []byte
+		walkPNG(data, func(typ string, chunk, _ []byte) bool {
+			if typ == "eXIf" {
+				block = chunk
+				return false
+			}
+			return true
+		})
+		return block
+	case isWebP(data):
+		var block []byte
+		walkWebP(data, func(fourcc string, chunk, _ []byte) bool {
+			if fourcc == "EXIF" {
+				block = chunk
+				return false
+			}
+			return true
+		})
+		return block
+	}
+	return nil
+}
+
+var errMalformed = errors.New("imaging: malformed container")
+
+// StripMetadata removes EXIF, XMP, IPTC and text chunks from a JPEG, PNG or
+// WebP file without touching the image data.
+func StripMetadata(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "jpeg":
+		return stripJPEG(data)
+	case "png":
+		return stripPNG(data)
+	case "webp":
+		return stripWebP(data)
+	}
+	return data, nil
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformed
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, errMalformed
+		}
+		marker := data[i+1]
+		// Start of scan: the entropy-coded data and everything after it stays
+		if marker == 0xDA {
+			return append(out, data[i:]...), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errMalformed
+		}
+		// APP1 carries EXIF and XMP, APP13 carries IPTC, COM is free text
+		if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return nil, errMalformed
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// walkPNG calls fn with each chunk's type, payload and raw bytes (length,
+// type, payload and CRC) until fn returns false.
+func walkPNG(data []byte, fn func(typ string, chunk, raw []byte) bool) error {
+	i := len(pngSignature)
+	for i+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return errMalformed
+		}
+		if !fn(string(data[i+4:i+8]), data[i+8:i+8+length], data[i:end]) {
+			return nil
+		}
+		i = end
+	}
+	return nil
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	err := walkPNG(data, func(typ string, _, raw []byte) bool {
+		switch typ {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+		default:
+			out = append(out, raw...)
+		}
+		return true
+	})
+	return out, err
+}
+
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// walkWebP calls fn with each RIFF chunk's FourCC, payload and raw bytes
+// (header, payload and padding) until fn returns false.
+func walkWebP(data []byte, fn func(fourcc string, chunk, raw []byte) bool) error {
+	i := 12
+	for i+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if size < 0 || i+8+size > len(data) {
+			return errMalformed
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		if !fn(string(data[i:i+4]), data[i+8:i+8+size], data[i:end]) {
+			return nil
+		}
+		i = end
+	}
+	return nil
+}
+
+func stripWebP(data []byte) ([]byte, error) {
+	if !isWebP(data) {
+		return nil, errMalformed
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	err := walkWebP(data, func(fourcc string, _, raw []byte) bool {
+		switch fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			// Clear the EXIF (0x08) and XMP (0x04) presence flags
+			chunk := append([]byte(nil), raw...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, raw...)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
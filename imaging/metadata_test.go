@@ -0,0 +1,209 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+)
+
+// Where the fixtures claim to have been taken: 52°30' N, 13°24' E.
+const (
+	fixtureLat = 52.5
+	fixtureLng = 13.4
+)
+
+// exifTIFF builds a little-endian EXIF block with an orientation and, with
+// gps set, the fixture location.
+func exifTIFF(orientation int, gps bool) []byte {
+	le := binary.LittleEndian
+	var b []byte
+	u16 := func(v uint16) { b = le.AppendUint16(b, v) }
+	u32 := func(v uint32) { b = le.AppendUint32(b, v) }
+	entry := func(tag, typ uint16, count, value uint32) { u16(tag); u16(typ); u32(count); u32(value) }
+	const short, long, ascii, rational = 3, 4, 2, 5
+
+	b = append(b, "II*\x00"...)
+	u32(8)
+
+	// IFD0 at 8: orientation and the GPS IFD pointer
+	entries := uint16(1)
+	if gps {
+		entries++
+	}
+	u16(entries)
+	entry(0x0112, short, 1, uint32(orientation))
+	gpsIFD := uint32(8 + 2 + 12*int(entries) + 4)
+	if gps {
+		entry(0x8825, long, 1, gpsIFD)
+	}
+	u32(0)
+	if !gps {
+		return b
+	}
+
+	// GPS IFD, then the two degree/minute/second triples
+	latAt := gpsIFD + 2 + 4*12 + 4
+	lngAt := latAt + 24
+	u16(4)
+	entry(0x0001, ascii, 2, uint32('N'))
+	entry(0x0002, rational, 3, latAt)
+	entry(0x0003, ascii, 2, uint32('E'))
+	entry(0x0004, rational, 3, lngAt)
+	u32(0)
+	for _, v := range []uint32{52, 1, 30, 1, 0, 1, 13, 1, 24, 1, 0, 1} {
+		u32(v)
+	}
+	return b
+}
+
+// withJPEGExif inserts tiff as an APP1 segment right after SOI.
+func withJPEGExif(data, tiff []byte) []byte {
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(2+6+len(tiff)))
+	seg = append(append(seg, "Exif\x00\x00"...), tiff...)
+	return append(append(append([]byte(nil), data[:2]...), seg...), data[2:]...)
+}
+
+// withPNGExif inserts tiff as an eXIf chunk, plus a tEXt chunk, after IHDR.
+func withPNGExif(data, tiff []byte) []byte {
+	ihdrEnd := len(pngSignature) + 12 + 13
+	out := append([]byte(nil), data[:ihdrEnd]...)
+	out = append(out, pngChunk("eXIf", tiff)...)
+	out = append(out, pngChunk("tEXt", []byte("Comment\x00taken at home"))...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+// withWebPExif turns a simple WebP into an extended one carrying tiff in an
+// EXIF chunk, as cameras and editors write them.
+func withWebPExif(data, tiff []byte, w, h int) []byte {
+	le := binary.LittleEndian
+	vp8x := []byte("VP8X")
+	vp8x = le.AppendUint32(vp8x, 10)
+	vp8x = append(vp8x, 0x08, 0, 0, 0)
+	vp8x = append(vp8x, byte(w-1), byte((w-1)>>8), byte((w-1)>>16))
+	vp8x = append(vp8x, byte(h-1), byte((h-1)>>8), byte((h-1)>>16))
+
+	exifChunk := le.AppendUint32([]byte("EXIF"), uint32(len(tiff)))
+	exifChunk = append(exifChunk, tiff...)
+	if len(tiff)%2 == 1 {
+		exifChunk = append(exifChunk, 0)
+	}
+
+	out := append([]byte(nil), data[:12]...)
+	out = append(out, vp8x...)
+	out = append(out, data[12:]...)
+	out = append(out, exifChunk...)
+	le.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// gpsFixtures returns a 4x3 image in every format with the fixture location
+// in its metadata.
+func gpsFixtures(t *testing.T) map[string][]byte {
+	img := testImage(4, 3)
+	tiff := exifTIFF(1, true)
+	return map[string][]byte{
+		"jpeg": withJPEGExif(encodeTest(t, img, "jpeg"), tiff),
+		"png":  withPNGExif(encodeTest(t, img, "png"), tiff),
+		"webp": withWebPExif(encodeTest(t, img, "webp"), tiff, 4, 3),
+	}
+}
+
+func TestExtractMetadataGPS(t *testing.T) {
+	for format, data := range gpsFixtures(t) {
+		t.Run(format, func(t *testing.T) {
+			meta := ExtractMetadata(data)
+			if meta.GPS == nil {
+				t.Fatal("no GPS found")
+			}
+			if math.Abs(meta.GPS.Latitude-fixtureLat) > 1e-6 || math.Abs(meta.GPS.Longitude-fixtureLng) > 1e-6 {
+				t.Errorf("GPS = %v, want %v,%v", *meta.GPS, fixtureLat, fixtureLng)
+			}
+			if meta.Orientation != 1 {
+				t.Errorf("orientation = %d, want 1", meta.Orientation)
+			}
+		})
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	for format, data := range gpsFixtures(t) {
+		t.Run(format, func(t *testing.T) {
+			clean, err := StripMetadata(data, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta := ExtractMetadata(clean); meta.GPS != nil || meta.Orientation != 0 {
+				t.Errorf("metadata left after stripping: %+v", meta)
+			}
+			for _, marker := range []string{"Exif", "eXIf", "tEXt", "EXIF"} {
+				if bytes.Contains(clean, []byte(marker)) {
+					t.Errorf("%q still in the file", marker)
+				}
+			}
+
+			// The pixels are untouched, the file still decodes as before
+			d, err := Inspect(clean, DefaultLimits)
+			if err != nil {
+				t.Fatalf("stripped file: %v", err)
+			}
+			if d.Format != format || d.Width != 4 || d.Height != 3 {
+				t.Errorf("stripped file is %s %dx%d, want %s 4x3", d.Format, d.Width, d.Height, format)
+			}
+		})
+	}
+}
+
+func TestStripMetadataWebPFlags(t *testing.T) {
+	data := gpsFixtures(t)["webp"]
+	clean, err := StripMetadata(data, "webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flags byte = 0xFF
+	walkWebP(clean, func(fourcc string, chunk, _ []byte) bool {
+		if fourcc == "VP8X" {
+			flags = chunk[0]
+		}
+		return true
+	})
+	if flags&(0x08|0x04) != 0 {
+		t.Errorf("VP8X flags %#x still announce EXIF or XMP", flags)
+	}
+	if got := binary.LittleEndian.Uint32(clean[4:]); int(got) != len(clean)-8 {
+		t.Errorf("RIFF size %d, file has %d", got, len(clean)-8)
+	}
+}
+
+func TestSanitizeRotates(t *testing.T) {
+	// Stored sideways with orientation 6, as phones do
+	data := withJPEGExif(encodeTest(t, testImage(4, 3), "jpeg"), exifTIFF(6, true))
+	d, err := Inspect(data, DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Sanitize(data, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Metadata.Width != 3 || s.Metadata.Height != 4 || s.Metadata.Orientation != 6 {
+		t.Errorf("metadata %dx%d orientation %d, want 3x4 orientation 6",
+			s.Metadata.Width, s.Metadata.Height, s.Metadata.Orientation)
+	}
+	if s.Metadata.GPS == nil {
+		t.Error("GPS not extracted before stripping")
+	}
+	if meta := ExtractMetadata(s.Data); meta.GPS != nil || meta.Orientation != 0 {
+		t.Errorf("metadata left in the stored file: %+v", meta)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(s.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 3 || cfg.Height != 4 {
+		t.Errorf("stored file is %dx%d, want 3x4", cfg.Width, cfg.Height)
+	}
+}
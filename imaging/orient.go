@@ -0,0 +1,46 @@
+package imaging
+
+import "image"
+
+// Orient returns img transformed so that it displays upright for the given
+// EXIF orientation (1-8). Orientation 0 or 1 returns img unchanged.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	// src maps a destination pixel back to the source pixel it comes from
+	var src func(x, y int) (int, int)
+	switch orientation {
+	case 2: // mirrored horizontally
+		src = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // rotated 180
+		src = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // mirrored vertically
+		src = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // transposed
+		src = func(x, y int) (int, int) { return y, x }
+	case 6: // rotated 90 clockwise
+		src = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // transversed
+		src = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // rotated 90 counter-clockwise
+		src = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := src(x, y)
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
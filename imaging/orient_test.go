@@ -0,0 +1,61 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// gridImage draws rows of letters, one pixel per letter, so a transformed
+// image can be read back and compared as text.
+func gridImage(rows ...string) image.Image {
+	img := image.NewGray(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x := range row {
+			img.SetGray(x, y, color.Gray{Y: row[x]})
+		}
+	}
+	return img
+}
+
+func gridRows(img image.Image) []string {
+	b := img.Bounds()
+	rows := make([]string, b.Dy())
+	for y := range rows {
+		row := make([]byte, b.Dx())
+		for x := range row {
+			row[x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+		}
+		rows[y] = string(row)
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+	upright := []string{"abc", "def"}
+	// How each orientation stores the upright image
+	stored := map[int][]string{
+		1: {"abc", "def"},
+		2: {"cba", "fed"},
+		3: {"fed", "cba"},
+		4: {"def", "abc"},
+		5: {"ad", "be", "cf"},
+		6: {"cf", "be", "ad"},
+		7: {"fc", "eb", "da"},
+		8: {"da", "eb", "fc"},
+	}
+	for orientation := 1; orientation <= 8; orientation++ {
+		got := gridRows(Orient(gridImage(stored[orientation]...), orientation))
+		if len(got) != len(upright) || got[0] != upright[0] || got[1] != upright[1] {
+			t.Errorf("orientation %d: got %q, want %q", orientation, got, upright)
+		}
+	}
+
+	// Unknown values leave the image alone
+	for _, orientation := range []int{0, 9} {
+		img := gridImage(upright...)
+		if Orient(img, orientation) != img {
+			t.Errorf("orientation %d changed the image", orientation)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package imaging
+
+import (
+	"image"
+	"math/bits"
+
+	"golang.org/x/image/draw"
+)
+
+// DHash computes a 64-bit difference hash of img: the image is shrunk to 9x8
+// grayscale pixels and each bit records whether a pixel is brighter than its
+// right-hand neighbour. Re-encoded, resized or slightly edited copies of the
+// same picture end up a few bits apart.
+func DHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance returns the number of differing bits between two hashes.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
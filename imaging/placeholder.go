@@ -0,0 +1,167 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Placeholder is what a client can show while the real image loads.
+type Placeholder struct {
+	BlurHash string
+	// Color is the dominant color as #rrggbb.
+	Color string
+}
+
+// NewPlaceholder computes the placeholder of img. Both values come from a
+// small copy of the image, so it is cheap even for large originals.
+func NewPlaceholder(img image.Image) Placeholder {
+	small := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	b := img.Bounds()
+	if b.Dx() > b.Dy() {
+		small.Rect.Max.Y = max(1, 64*b.Dy()/b.Dx())
+	} else {
+		small.Rect.Max.X = max(1, 64*b.Dx()/b.Dy())
+	}
+	draw.ApproxBiLinear.Scale(small, small.Rect, img, b, draw.Src, nil)
+
+	// More components along the longer side
+	x, y := 4, 3
+	if b.Dy() > b.Dx() {
+		x, y = 3, 4
+	}
+	return Placeholder{BlurHash: BlurHash(small, x, y), Color: DominantColor(small)}
+}
+
+// BlurHash encodes img following https://blurha.sh with xComponents by
+// yComponents cosine components (each between 1 and 9). It walks every
+// pixel, so pass a small image.
+func BlurHash(img image.Image, xComponents, yComponents int) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// Linear RGB of every pixel, converted once
+	pixels := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			pixels[y*w+x] = [3]float64{srgbToLinear(r >> 8), srgbToLinear(g >> 8), srgbToLinear(bl >> 8)}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				cy := math.Cos(math.Pi * float64(j) * float64(y) / float64(h))
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) * cy
+					p := pixels[y*w+x]
+					f[0] += basis * p[0]
+					f[1] += basis * p[1]
+					f[2] += basis * p[2]
+				}
+			}
+			scale := 1.0
+			if i != 0 || j != 0 {
+				scale = 2
+			}
+			scale /= float64(w * h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			actualMax = max(actualMax, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantisedMax := int(max(0, min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		sb.WriteString(encode83(quantisedMax, 1))
+	} else {
+		sb.WriteString(encode83(0, 1))
+	}
+
+	sb.WriteString(encode83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(max(0, min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		sb.WriteString(encode83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+	return sb.String()
+}
+
+// DominantColor returns the most common color of img as #rrggbb. Colors are
+// counted in coarse buckets and the winner is the average of its bucket, so
+// noise and gradients don't split the vote. Transparent pixels are skipped.
+func DominantColor(img image.Image) string {
+	type bucket struct{ n, r, g, b int }
+	var buckets [4096]bucket
+	best := -1
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			// Undo premultiplication
+			r, g, bl = r*0xffff/a>>8, g*0xffff/a>>8, bl*0xffff/a>>8
+			i := int(r>>4)<<8 | int(g>>4)<<4 | int(bl>>4)
+			buckets[i].n++
+			buckets[i].r += int(r)
+			buckets[i].g += int(g)
+			buckets[i].b += int(bl)
+			if best < 0 || buckets[i].n > buckets[best].n {
+				best = i
+			}
+		}
+	}
+	if best < 0 {
+		return "#000000"
+	}
+	w := buckets[best]
+	return fmt.Sprintf("#%02x%02x%02x", w.r/w.n, w.g/w.n, w.b/w.n)
+}
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encode83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = base83[value%83]
+		value /= 83
+	}
+	return string(out)
+}
+
+func srgbToLinear(v uint32) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = max(0, min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,65 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBlurHash(t *testing.T) {
+	// Expected hashes follow the reference encoder at https://blurha.sh
+	// (github.com/woltapp/blurhash) for the same pixels.
+	gradient := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	quadrants := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			gradient.Set(x, y, color.RGBA{uint8(x * 255 / 31), uint8(y * 255 / 23), uint8(255 - x*255/31), 255})
+			if (x < 16) == (y < 12) {
+				quadrants.Set(x, y, color.White)
+			} else {
+				quadrants.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+		x, y int
+		want string
+	}{
+		{"gradient", gradient, 4, 3, "L.Hewe2zw%XAl}WFjue=gJfjfQfj"},
+		{"quadrants", quadrants, 4, 3, "L+Lqe9t7fQt7t7~qt7IUfQt7j[WB"},
+		{"solid red", solid(8, 8, color.RGBA{255, 0, 0, 255}), 1, 1, "00TI:j"},
+	} {
+		if got := BlurHash(tc.img, tc.x, tc.y); got != tc.want {
+			t.Errorf("%s: BlurHash = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDominantColor(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+		want string
+	}{
+		{"solid", solid(4, 4, color.RGBA{0x12, 0x34, 0x56, 0xff}), "#123456"},
+		// Premultiplied half-transparent red still counts as full red
+		{"semi-transparent", solid(4, 4, color.RGBA{0x80, 0, 0, 0x80}), "#ff0000"},
+		{"mostly transparent", solid(4, 4, color.NRGBA{0xff, 0xff, 0xff, 0x40}), "#000000"},
+	} {
+		if got := DominantColor(tc.img); got != tc.want {
+			t.Errorf("%s: DominantColor = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	// Pixels below half opacity don't vote, even when they are the majority
+	img := solid(4, 4, color.NRGBA{0, 0, 0xff, 0x10})
+	for x := 0; x < 4; x++ {
+		img.Set(x, 0, color.NRGBA{0, 0xff, 0, 0xc0})
+	}
+	if got := DominantColor(img); got != "#00ff00" {
+		t.Errorf("mixed opacity: DominantColor = %s, want #00ff00", got)
+	}
+}
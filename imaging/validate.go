@@ -0,0 +1,86 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+)
+
+// Limits bounds what Inspect is willing to decode. Checking them against the
+// header before decoding keeps a small file that claims huge dimensions (a
+// decompression bomb) from allocating gigabytes of pixels.
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+}
+
+// DefaultLimits allows anything up to 12000px per side and 50 megapixels.
+var DefaultLimits = Limits{
+	MaxWidth:  12000,
+	MaxHeight: 12000,
+	MaxPixels: 50_000_000,
+}
+
+var (
+	// ErrUnsupportedType means the bytes are not a JPEG, PNG or WebP image.
+	ErrUnsupportedType = errors.New("imaging: unsupported image type")
+	// ErrTooLarge means the image exceeds the configured Limits.
+	ErrTooLarge = errors.New("imaging: image dimensions exceed limits")
+	// ErrCorrupt means the header looked fine but the image failed to decode.
+	ErrCorrupt = errors.New("imaging: image could not be decoded")
+)
+
+var sniffedFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/webp": "webp",
+}
+
+// Decoded is a validated image.
+type Decoded struct {
+	Image    image.Image
+	Format   string
+	MIMEType string
+	Width    int
+	Height   int
+}
+
+// Inspect sniffs the real type of data from its bytes, checks the declared
+// dimensions against limits and then fully decodes it. The client-supplied
+// Content-Type plays no part in this.
+func Inspect(data []byte, limits Limits) (*Decoded, error) {
+	mimeType := http.DetectContentType(data)
+	format, ok := sniffedFormats[mimeType]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, cfgFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if cfgFormat != format {
+		return nil, ErrUnsupportedType
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 ||
+		cfg.Width > limits.MaxWidth || cfg.Height > limits.MaxHeight ||
+		int64(cfg.Width)*int64(cfg.Height) > limits.MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	return &Decoded{
+		Image:    img,
+		Format:   format,
+		MIMEType: mimeType,
+		Width:    cfg.Width,
+		Height:   cfg.Height,
+	}, nil
+}
@@ -0,0 +1,92 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testImage is a small image with a different color in every pixel.
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(40 * x), uint8(40 * y), 200, 255})
+		}
+	}
+	return img
+}
+
+func encodeTest(t *testing.T, img image.Image, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, format); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func pngChunk(typ string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(append(chunk, typ...), payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngHeader is a PNG that stops after its IHDR chunk claiming w by h pixels.
+// That is all DecodeConfig reads, which is the point of a decompression bomb.
+func pngHeader(w, h uint32) []byte {
+	ihdr := binary.BigEndian.AppendUint32(nil, w)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, h)
+	ihdr = append(ihdr, 8, 2, 0, 0, 0) // 8-bit RGB
+	return append(append([]byte(nil), pngSignature...), pngChunk("IHDR", ihdr)...)
+}
+
+func TestInspect(t *testing.T) {
+	small := Limits{MaxWidth: 10, MaxHeight: 10, MaxPixels: 60}
+	png := encodeTest(t, testImage(8, 6), "png")
+
+	tests := []struct {
+		name   string
+		data   []byte
+		limits Limits
+		want   error
+		format string
+	}{
+		{"jpeg", encodeTest(t, testImage(8, 6), "jpeg"), DefaultLimits, nil, "jpeg"},
+		{"png", png, DefaultLimits, nil, "png"},
+		{"webp", encodeTest(t, testImage(8, 6), "webp"), DefaultLimits, nil, "webp"},
+		{"at the limits", png, Limits{MaxWidth: 8, MaxHeight: 6, MaxPixels: 48}, nil, "png"},
+		{"too wide", encodeTest(t, testImage(11, 2), "png"), small, ErrTooLarge, ""},
+		{"too tall", encodeTest(t, testImage(2, 11), "png"), small, ErrTooLarge, ""},
+		{"too many pixels", encodeTest(t, testImage(8, 8), "png"), small, ErrTooLarge, ""},
+		{"bomb", pngHeader(100_000, 100_000), DefaultLimits, ErrTooLarge, ""},
+		{"bomb within sides", pngHeader(12_000, 12_000), DefaultLimits, ErrTooLarge, ""},
+		{"zero size", pngHeader(0, 10), DefaultLimits, ErrCorrupt, ""},
+		{"truncated", png[:len(png)-20], DefaultLimits, ErrCorrupt, ""},
+		{"header only", pngHeader(8, 6), DefaultLimits, ErrCorrupt, ""},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), DefaultLimits, ErrUnsupportedType, ""},
+		{"text", []byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), DefaultLimits, ErrUnsupportedType, ""},
+		{"empty", nil, DefaultLimits, ErrUnsupportedType, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Inspect(tt.data, tt.limits)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			if d.Format != tt.format || d.MIMEType != ContentType(tt.format) {
+				t.Errorf("format %s (%s), want %s", d.Format, d.MIMEType, tt.format)
+			}
+			if b := d.Image.Bounds(); d.Width != b.Dx() || d.Height != b.Dy() {
+				t.Errorf("size %dx%d, decoded %v", d.Width, d.Height, b)
+			}
+		})
+	}
+}
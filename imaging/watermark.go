@@ -0,0 +1,106 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Watermark positions.
+const (
+	TopLeft     = "top-left"
+	TopRight    = "top-right"
+	BottomLeft  = "bottom-left"
+	BottomRight = "bottom-right"
+	Center      = "center"
+)
+
+// Watermark is an overlay drawn onto public derivatives. Overlay wins over
+// Text when both are set.
+type Watermark struct {
+	Overlay  image.Image
+	Text     string
+	Position string
+	// Opacity runs from 0 (invisible) to 1.
+	Opacity float64
+	// Scale is the overlay's width as a fraction of the image width.
+	Scale float64
+}
+
+// ValidPosition reports whether p is one of the watermark positions.
+func ValidPosition(p string) bool {
+	switch p {
+	case TopLeft, TopRight, BottomLeft, BottomRight, Center:
+		return true
+	}
+	return false
+}
+
+// ApplyWatermark returns a copy of img with wm drawn on top. The original is
+// left untouched.
+func ApplyWatermark(img image.Image, wm *Watermark) image.Image {
+	mark := wm.Overlay
+	if mark == nil {
+		if wm.Text == "" {
+			return img
+		}
+		mark = renderText(wm.Text)
+	}
+
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	// Scale the mark to its share of the width, keeping its aspect ratio
+	mb := mark.Bounds()
+	w := max(1, int(float64(b.Dx())*wm.Scale))
+	h := max(1, mb.Dy()*w/mb.Dx())
+	if h > b.Dy() {
+		h = b.Dy()
+		w = max(1, mb.Dx()*h/mb.Dy())
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), mark, mb, draw.Src, nil)
+
+	margin := min(b.Dx(), b.Dy()) / 40
+	var at image.Point
+	switch wm.Position {
+	case TopLeft:
+		at = image.Pt(margin, margin)
+	case TopRight:
+		at = image.Pt(b.Dx()-w-margin, margin)
+	case BottomLeft:
+		at = image.Pt(margin, b.Dy()-h-margin)
+	case Center:
+		at = image.Pt((b.Dx()-w)/2, (b.Dy()-h)/2)
+	default:
+		at = image.Pt(b.Dx()-w-margin, b.Dy()-h-margin)
+	}
+
+	alpha := image.NewUniform(color.Alpha{A: uint8(max(0, min(1, wm.Opacity)) * 255)})
+	draw.DrawMask(dst, image.Rectangle{Min: at, Max: at.Add(image.Pt(w, h))}, scaled, image.Point{}, alpha, image.Point{}, draw.Over)
+	return dst
+}
+
+// renderText draws s in white with a dark shadow on a transparent background,
+// at the size of the built-in bitmap font. ApplyWatermark scales it up.
+func renderText(s string) image.Image {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, s).Ceil() + 1
+	img := image.NewRGBA(image.Rect(0, 0, width, face.Height+1))
+
+	d := &font.Drawer{Dst: img, Face: face}
+	for _, layer := range []struct {
+		c      color.Color
+		offset int
+	}{{color.RGBA{A: 160}, 1}, {color.White, 0}} {
+		d.Src = image.NewUniform(layer.c)
+		d.Dot = fixed.P(layer.offset, face.Ascent+layer.offset)
+		d.DrawString(s)
+	}
+	return img
+}
@@ -0,0 +1,127 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// solid returns a w×h image filled with c.
+func solid(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestValidPosition(t *testing.T) {
+	for _, p := range []string{TopLeft, TopRight, BottomLeft, BottomRight, Center} {
+		if !ValidPosition(p) {
+			t.Errorf("ValidPosition(%q) = false", p)
+		}
+	}
+	for _, p := range []string{"", "middle", "Top-Left"} {
+		if ValidPosition(p) {
+			t.Errorf("ValidPosition(%q) = true", p)
+		}
+	}
+}
+
+func TestApplyWatermarkPosition(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+	black := solid(10, 10, color.RGBA{0, 0, 0, 255})
+
+	// A 400×400 image gets a 100×100 mark 10px from the edges
+	for _, tc := range []struct {
+		position string
+		inside   image.Point
+	}{
+		{TopLeft, image.Pt(20, 20)},
+		{TopRight, image.Pt(380, 20)},
+		{BottomLeft, image.Pt(20, 380)},
+		{BottomRight, image.Pt(380, 380)},
+		{Center, image.Pt(200, 200)},
+	} {
+		src := solid(400, 400, white)
+		out := ApplyWatermark(src, &Watermark{Overlay: black, Position: tc.position, Opacity: 1, Scale: 0.25})
+
+		if r, _, _, _ := out.At(tc.inside.X, tc.inside.Y).RGBA(); r != 0 {
+			t.Errorf("%s: pixel %v not covered by the mark", tc.position, tc.inside)
+		}
+		marked := 0
+		for y := 0; y < 400; y++ {
+			for x := 0; x < 400; x++ {
+				if r, _, _, _ := out.At(x, y).RGBA(); r != 0xffff {
+					marked++
+				}
+			}
+		}
+		if marked != 100*100 {
+			t.Errorf("%s: %d pixels marked, want %d", tc.position, marked, 100*100)
+		}
+		if src.RGBAAt(tc.inside.X, tc.inside.Y) != white {
+			t.Errorf("%s: source image was modified", tc.position)
+		}
+	}
+}
+
+func TestApplyWatermarkOpacity(t *testing.T) {
+	src := solid(100, 100, color.RGBA{255, 255, 255, 255})
+	out := ApplyWatermark(src, &Watermark{
+		Overlay:  solid(10, 10, color.RGBA{0, 0, 0, 255}),
+		Position: Center,
+		Opacity:  0.5,
+		Scale:    0.5,
+	})
+	r, _, _, _ := out.At(50, 50).RGBA()
+	if got := r >> 8; got < 120 || got > 135 {
+		t.Errorf("half opacity black over white = %d, want about 128", got)
+	}
+}
+
+func TestApplyWatermarkText(t *testing.T) {
+	src := solid(200, 100, color.RGBA{40, 40, 40, 255})
+	if out := ApplyWatermark(src, &Watermark{Position: BottomRight, Opacity: 1, Scale: 0.5}); out != image.Image(src) {
+		t.Error("a watermark without text or overlay changed the image")
+	}
+
+	out := ApplyWatermark(src, &Watermark{Text: "dimas", Position: BottomRight, Opacity: 1, Scale: 0.5})
+	var light int
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			if r, _, _, _ := out.At(x, y).RGBA(); r>>8 > 200 {
+				if x < 90 || y < 50 {
+					t.Fatalf("text drawn at (%d, %d), outside the bottom-right corner", x, y)
+				}
+				light++
+			}
+		}
+	}
+	if light == 0 {
+		t.Error("no text drawn")
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	mark := renderText("abc")
+	b := mark.Bounds()
+	if b.Dy() != 14 || b.Dx() < 3*7 {
+		t.Errorf("bounds %v, want 14px high and at least 21px wide", b)
+	}
+	var opaque, clear int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := mark.At(x, y).RGBA(); a == 0 {
+				clear++
+			} else {
+				opaque++
+			}
+		}
+	}
+	if opaque == 0 || clear == 0 {
+		t.Errorf("%d drawn and %d transparent pixels, want both", opaque, clear)
+	}
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// File writes every message to its own .eml file in a directory, where
+// local runs and tests can pick them up.
+type File struct {
+	dir string
+}
+
+// NewFile creates a File backend writing to dir.
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir}, nil
+}
+
+func (f *File) Send(ctx context.Context, msg Message) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.New().String()[:8])
+	return os.WriteFile(filepath.Join(f.dir, name), compose("porto@localhost", msg), 0o644)
+}
+
+// Log prints messages to the standard logger instead of sending them.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,63 @@
+// Package mail sends the API's transactional emails (verification, password
+// reset) through SMTP, or writes them to disk or the log for local runs and
+// tests.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is implemented by every backend.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv builds the mailer selected by DIMAS_MAIL_BACKEND ("smtp", "file"
+// or "log"). There is no default: a deployment that forgot to configure SMTP
+// would otherwise write reset links to its log, so "log" has to be chosen.
+//
+//	smtp: DIMAS_SMTP_HOST, DIMAS_SMTP_PORT (587), DIMAS_SMTP_USERNAME,
+//	      DIMAS_SMTP_PASSWORD, DIMAS_MAIL_FROM
+//	file: DIMAS_MAIL_DIR (./mail)
+func FromEnv() (Mailer, error) {
+	switch backend := os.Getenv("DIMAS_MAIL_BACKEND"); backend {
+	case "":
+		return nil, fmt.Errorf("mail: DIMAS_MAIL_BACKEND is not set (smtp, file or log)")
+	case "log":
+		return Log{}, nil
+	case "file":
+		dir := os.Getenv("DIMAS_MAIL_DIR")
+		if dir == "" {
+			dir = "./mail"
+		}
+		return NewFile(dir)
+	case "smtp":
+		port := 587
+		if p := os.Getenv("DIMAS_SMTP_PORT"); p != "" {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("mail: invalid DIMAS_SMTP_PORT %q", p)
+			}
+			port = n
+		}
+		return NewSMTP(SMTPConfig{
+			Host:     os.Getenv("DIMAS_SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("DIMAS_SMTP_USERNAME"),
+			Password: os.Getenv("DIMAS_SMTP_PASSWORD"),
+			From:     os.Getenv("DIMAS_MAIL_FROM"),
+		})
+	default:
+		return nil, fmt.Errorf("mail: unknown backend %q", backend)
+	}
+}
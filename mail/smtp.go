@@ -0,0 +1,87 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPConfig configures the SMTP backend.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the sender address, e.g. "Porto <noreply@example.com>".
+	From string
+}
+
+// SMTP delivers through an SMTP server, upgrading to TLS with STARTTLS when
+// the server offers it.
+type SMTP struct {
+	cfg  SMTPConfig
+	from string
+}
+
+// NewSMTP creates an SMTP backend.
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	if cfg.Host == "" || cfg.From == "" {
+		return nil, errors.New("mail: SMTP needs a host and a from address")
+	}
+	from, err := envelopeAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("mail: invalid from address: %w", err)
+	}
+	return &SMTP{cfg: cfg, from: from}, nil
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	// net/smtp has no context support, run it aside and give up on cancel
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, s.from, []string{msg.To}, compose(s.cfg.From, msg))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// compose renders msg as an RFC 5322 message.
+func compose(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// envelopeAddress returns the bare address of a From header value.
+func envelopeAddress(from string) (string, error) {
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", err
+	}
+	return addr.Address, nil
+}
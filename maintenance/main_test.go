@@ -0,0 +1,65 @@
+package maintenance
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"golang-api/storage"
+)
+
+// testDB connects to the migrated database at TEST_DATABASE_URL, skipping
+// the test when there is none.
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := pgxpool.Connect(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+func testStore() *storage.Memory {
+	return storage.NewMemory("http://localhost/api/storage", []byte("test-signing-key"))
+}
+
+// testImageRow adds an active image pointing at key to a new category; both
+// are removed when the test ends.
+func testImageRow(t *testing.T, db *pgxpool.Pool, key string) int {
+	t.Helper()
+	ctx := context.Background()
+	var category, id int
+	err := db.QueryRow(ctx, `INSERT INTO categories (name) VALUES ($1) RETURNING id`, "test-"+uuid.New().String()[:8]).Scan(&category)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(ctx, `DELETE FROM images WHERE category_id = $1`, category)
+		db.Exec(ctx, `DELETE FROM categories WHERE id = $1`, category)
+	})
+	err = db.QueryRow(ctx,
+		`INSERT INTO images (name, category_id, description, s3_key, content_type, size_bytes)
+		VALUES ('test', $1, '', $2, 'image/png', 0) RETURNING id`, category, key,
+	).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// putObject stores data under key.
+func putObject(t *testing.T, store storage.Storage, key, data string) {
+	t.Helper()
+	if err := store.Put(context.Background(), key, strings.NewReader(data), int64(len(data)), ""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,70 @@
+// Package maintenance holds the housekeeping jobs shared by the admin API
+// endpoints and the command line tool.
+package maintenance
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"golang-api/storage"
+)
+
+// ExpirePendingUploads removes images rows that were created by an upload
+// initiation but never completed, together with any object the client
+// already wrote. It returns the number of rows removed.
+func ExpirePendingUploads(ctx context.Context, db *pgxpool.Pool, store storage.Storage) (int, error) {
+	rows, err := db.Query(ctx,
+		`DELETE FROM images
+		WHERE status = 'pending' AND upload_expires_at < now()
+		RETURNING s3_key`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return len(keys), deleteObjects(ctx, store, keys)
+}
+
+// ExpireTusUploads removes resumable uploads past their expiry, finished or
+// not, along with the chunks they still hold. It returns the number of
+// uploads removed.
+func ExpireTusUploads(ctx context.Context, db *pgxpool.Pool, store storage.Storage) (int, error) {
+	keys, err := queryKeys(ctx, db,
+		`SELECT ch.s3_key FROM tus_upload_chunks ch
+		JOIN tus_uploads u ON u.id = ch.upload_id
+		WHERE u.expires_at < now()`)
+	if err != nil {
+		return 0, err
+	}
+	tag, err := db.Exec(ctx, `DELETE FROM tus_uploads WHERE expires_at < now()`)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), deleteObjects(ctx, store, keys)
+}
+
+// deleteObjects removes keys whose rows are already gone. A failed delete
+// only leaves an orphaned object behind, so it keeps going and returns the
+// first error.
+func deleteObjects(ctx context.Context, store storage.Storage, keys []string) error {
+	var firstErr error
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
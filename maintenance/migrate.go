@@ -0,0 +1,254 @@
+package maintenance
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"golang-api/storage"
+)
+
+// storedObjects returns every key the database points at together with the
+// checksum recorded for it, if any. Pending uploads have no object yet.
+const storedObjects = `
+	SELECT s3_key, sha256 FROM images WHERE status <> 'pending'
+	UNION ALL SELECT s3_key, NULL FROM image_variants
+	UNION ALL SELECT s3_key, sha256 FROM image_versions
+	UNION ALL SELECT overlay_key, NULL FROM watermark_settings WHERE overlay_key IS NOT NULL`
+
+// MigrateOptions controls MigrateObjects.
+type MigrateOptions struct {
+	// Name identifies the migration; progress is recorded under it and a
+	// later run with the same name skips what was already verified.
+	Name string
+	// FromPrefix and ToPrefix rename keys on the way: a key starting with
+	// FromPrefix is stored at the destination with ToPrefix instead. Both
+	// empty keeps every key as it is.
+	FromPrefix, ToPrefix string
+	// Workers is the number of objects copied at once.
+	Workers int
+}
+
+// DestKey returns the destination key of key.
+func (o MigrateOptions) DestKey(key string) string {
+	if o.FromPrefix == "" && o.ToPrefix == "" {
+		return key
+	}
+	if rest, ok := strings.CutPrefix(key, o.FromPrefix); ok {
+		return o.ToPrefix + rest
+	}
+	return key
+}
+
+// MigrateFailure is an object that could not be copied or verified.
+type MigrateFailure struct {
+	Key   string `json:"s3_key"`
+	Error string `json:"error"`
+}
+
+// MigrateReport is the outcome of MigrateObjects.
+type MigrateReport struct {
+	Migration  string           `json:"migration"`
+	Referenced int              `json:"referenced"`
+	Skipped    int              `json:"skipped"`
+	Copied     int              `json:"copied"`
+	Bytes      int64            `json:"bytes"`
+	Failed     []MigrateFailure `json:"failed"`
+}
+
+// MigrateObjects copies every object referenced by the database from src to
+// dst. Each copy is read back from dst and its SHA-256 compared with the
+// source (and with the checksum in the database where there is one) before
+// it is recorded as done. Nothing is deleted from src and the database keys
+// are left alone, see RewriteKeys.
+func MigrateObjects(ctx context.Context, db *pgxpool.Pool, src, dst storage.Storage, opts MigrateOptions) (*MigrateReport, error) {
+	if opts.Name == "" {
+		return nil, errors.New("migration name is required")
+	}
+	if opts.FromPrefix != opts.ToPrefix && (opts.FromPrefix == "" || !strings.HasPrefix(opts.ToPrefix, ImagePrefix)) {
+		// Reconcile only looks under ImagePrefix and would take everything
+		// moved elsewhere for dangling rows
+		return nil, fmt.Errorf("a prefix rewrite needs an old prefix and a new one starting with %q", ImagePrefix)
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+
+	rows, err := db.Query(ctx, storedObjects)
+	if err != nil {
+		return nil, err
+	}
+	expected := map[string]string{}
+	for rows.Next() {
+		var (
+			key string
+			sum *string
+		)
+		if err := rows.Scan(&key, &sum); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if sum != nil {
+			expected[key] = *sum
+		} else if _, ok := expected[key]; !ok {
+			expected[key] = ""
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Objects verified by an earlier run under the same destination key
+	done := map[string]bool{}
+	rows, err = db.Query(ctx,
+		`SELECT s3_key, dest_key FROM storage_migration_objects WHERE migration = $1`, opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key, destKey string
+		if err := rows.Scan(&key, &destKey); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		done[key] = destKey == opts.DestKey(key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &MigrateReport{Migration: opts.Name, Referenced: len(expected), Failed: []MigrateFailure{}}
+	var mu sync.Mutex
+	sem := make(chan struct{}, opts.Workers)
+	var wg sync.WaitGroup
+	for key, sum := range expected {
+		if done[key] {
+			report.Skipped++
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			destKey := opts.DestKey(key)
+			size, sum, err := copyVerified(ctx, src, dst, key, destKey, sum)
+			if err == nil {
+				_, err = db.Exec(ctx,
+					`INSERT INTO storage_migration_objects (migration, s3_key, dest_key, sha256, size_bytes)
+					VALUES ($1, $2, $3, $4, $5)
+					ON CONFLICT (migration, s3_key) DO UPDATE
+					SET dest_key = EXCLUDED.dest_key, sha256 = EXCLUDED.sha256,
+						size_bytes = EXCLUDED.size_bytes, verified_at = now()`,
+					opts.Name, key, destKey, sum, size)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Failed = append(report.Failed, MigrateFailure{Key: key, Error: err.Error()})
+				return
+			}
+			report.Copied++
+			report.Bytes += size
+		}()
+	}
+	wg.Wait()
+
+	return report, nil
+}
+
+// copyVerified copies key from src to destKey in dst, hashing on the way,
+// then reads the copy back and checks it hashes the same. It returns the size
+// and checksum of the copy; a non-empty sum is the checksum the source must
+// have.
+func copyVerified(ctx context.Context, src, dst storage.Storage, key, destKey, sum string) (int64, string, error) {
+	obj, err := src.Get(ctx, key)
+	if err != nil {
+		return 0, "", fmt.Errorf("read source: %w", err)
+	}
+	defer obj.Close()
+
+	h := sha256.New()
+	if err := dst.Put(ctx, destKey, io.TeeReader(obj, h), obj.Size, obj.ContentType); err != nil {
+		return 0, "", fmt.Errorf("write destination: %w", err)
+	}
+	copied := hex.EncodeToString(h.Sum(nil))
+	if sum != "" && copied != sum {
+		// The source itself doesn't match what was uploaded, don't spread it
+		dst.Delete(ctx, destKey)
+		return 0, "", fmt.Errorf("source checksum %s does not match recorded %s", copied, sum)
+	}
+
+	back, err := dst.Get(ctx, destKey)
+	if err != nil {
+		return 0, "", fmt.Errorf("read back: %w", err)
+	}
+	defer back.Close()
+	h.Reset()
+	size, err := io.Copy(h, back)
+	if err != nil {
+		return 0, "", fmt.Errorf("read back: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != copied {
+		return 0, "", fmt.Errorf("destination checksum %s does not match source %s", got, copied)
+	}
+	return size, copied, nil
+}
+
+// keyColumns lists the columns holding object keys that RewriteKeys updates.
+var keyColumns = []struct{ table, column string }{
+	{"images", "s3_key"},
+	{"image_variants", "s3_key"},
+	{"image_versions", "s3_key"},
+	{"watermark_settings", "overlay_key"},
+}
+
+// RewriteKeys points the database at the destination keys recorded by the
+// migration name. It refuses to run, and changes nothing, while any
+// referenced object has not been verified yet. It returns the number of rows
+// updated.
+func RewriteKeys(ctx context.Context, db *pgxpool.Pool, name string) (int64, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	var missing int
+	err = tx.QueryRow(ctx,
+		`SELECT count(*) FROM (`+storedObjects+`) k
+		WHERE NOT EXISTS (
+			SELECT 1 FROM storage_migration_objects m WHERE m.migration = $1 AND m.s3_key = k.s3_key
+		)`, name,
+	).Scan(&missing)
+	if err != nil {
+		return 0, err
+	}
+	if missing > 0 {
+		return 0, fmt.Errorf("%d referenced objects are not copied yet, run the copy again first", missing)
+	}
+
+	var updated int64
+	for _, kc := range keyColumns {
+		tag, err := tx.Exec(ctx,
+			`UPDATE `+kc.table+` t SET `+kc.column+` = m.dest_key
+			FROM storage_migration_objects m
+			WHERE m.migration = $1 AND m.s3_key = t.`+kc.column+` AND m.dest_key <> m.s3_key`, name)
+		if err != nil {
+			return 0, fmt.Errorf("rewrite %s.%s: %w", kc.table, kc.column, err)
+		}
+		updated += tag.RowsAffected()
+	}
+	return updated, tx.Commit(ctx)
+}
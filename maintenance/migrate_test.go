@@ -0,0 +1,143 @@
+package maintenance
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"golang-api/storage"
+)
+
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func readObject(t *testing.T, store storage.Storage, key string) string {
+	t.Helper()
+	obj, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCopyVerified(t *testing.T) {
+	src, dst := testStore(), testStore()
+	putObject(t, src, "images/a.png", "original")
+
+	size, sum, err := copyVerified(context.Background(), src, dst, "images/a.png", "images/moved/a.png", checksum("original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len("original")) || sum != checksum("original") {
+		t.Errorf("size %d sum %s, want %d %s", size, sum, len("original"), checksum("original"))
+	}
+	if got := readObject(t, dst, "images/moved/a.png"); got != "original" {
+		t.Errorf("copy holds %q", got)
+	}
+	if _, err := src.Stat(context.Background(), "images/a.png"); err != nil {
+		t.Errorf("source removed: %v", err)
+	}
+}
+
+func TestCopyVerifiedChecksumMismatch(t *testing.T) {
+	src, dst := testStore(), testStore()
+	putObject(t, src, "images/a.png", "bit rot")
+
+	_, _, err := copyVerified(context.Background(), src, dst, "images/a.png", "images/a.png", checksum("original"))
+	if err == nil || !strings.Contains(err.Error(), "does not match recorded") {
+		t.Fatalf("err = %v, want a checksum mismatch", err)
+	}
+	if _, err := dst.Stat(context.Background(), "images/a.png"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("mismatching copy left at the destination: %v", err)
+	}
+}
+
+func TestMigrateObjects(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	src, dst := testStore(), testStore()
+	name := "test-" + uuid.New().String()[:8]
+	t.Cleanup(func() {
+		db.Exec(ctx, `DELETE FROM storage_migration_objects WHERE migration = $1`, name)
+	})
+
+	good := ImagePrefix + uuid.New().String() + ".png"
+	bad := ImagePrefix + uuid.New().String() + ".png"
+	putObject(t, src, good, "good")
+	putObject(t, src, bad, "changed since upload")
+	goodID := testImageRow(t, db, good)
+	badID := testImageRow(t, db, bad)
+	db.Exec(ctx, `UPDATE images SET sha256 = $1 WHERE id = $2`, checksum("good"), goodID)
+	db.Exec(ctx, `UPDATE images SET sha256 = $1 WHERE id = $2`, checksum("uploaded"), badID)
+
+	opts := MigrateOptions{Name: name, FromPrefix: ImagePrefix, ToPrefix: ImagePrefix + "moved/", Workers: 2}
+	report, err := MigrateObjects(ctx, db, src, dst, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := map[string]bool{}
+	for _, f := range report.Failed {
+		failed[f.Key] = true
+	}
+	if failed[good] {
+		t.Errorf("%s failed: %v", good, report.Failed)
+	}
+	if got := readObject(t, dst, opts.DestKey(good)); got != "good" {
+		t.Errorf("copy holds %q", got)
+	}
+	if !failed[bad] {
+		t.Errorf("%s with a mismatching checksum was not reported", bad)
+	}
+	if _, err := dst.Stat(ctx, opts.DestKey(bad)); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("mismatching copy stored: %v", err)
+	}
+
+	// The bad object is still unverified, so no key may change
+	if _, err := RewriteKeys(ctx, db, name); err == nil {
+		t.Fatal("RewriteKeys ran with an unverified object")
+	}
+	var key string
+	db.QueryRow(ctx, `SELECT s3_key FROM images WHERE id = $1`, goodID).Scan(&key)
+	if key != good {
+		t.Errorf("key rewritten to %s after RewriteKeys refused", key)
+	}
+
+	// A second run skips what is verified
+	report, err = MigrateObjects(ctx, db, src, dst, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped == 0 {
+		t.Error("second run skipped nothing")
+	}
+	for _, f := range report.Failed {
+		if f.Key == good {
+			t.Errorf("verified object copied again and failed: %s", f.Error)
+		}
+	}
+}
+
+func TestMigrateObjectsOptions(t *testing.T) {
+	for _, opts := range []MigrateOptions{
+		{},
+		{Name: "x", FromPrefix: "images/", ToPrefix: "other/"},
+		{Name: "x", ToPrefix: "images/moved/"},
+	} {
+		if _, err := MigrateObjects(context.Background(), nil, nil, nil, opts); err == nil {
+			t.Errorf("%+v accepted", opts)
+		}
+	}
+}
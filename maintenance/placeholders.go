@@ -0,0 +1,113 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"golang-api/imaging"
+	"golang-api/storage"
+)
+
+// BackfillFailure is an image whose placeholder could not be computed.
+type BackfillFailure struct {
+	ID    int    `json:"id"`
+	Error string `json:"error"`
+}
+
+// PlaceholderReport is the outcome of BackfillPlaceholders.
+type PlaceholderReport struct {
+	Processed int               `json:"processed"`
+	Failed    []BackfillFailure `json:"failed"`
+	// NextAfterID is the cursor for the next batch.
+	NextAfterID int `json:"next_after_id"`
+	// Remaining counts images past the cursor still without a placeholder.
+	Remaining int `json:"remaining"`
+}
+
+// BackfillPlaceholders computes the BlurHash and dominant color of up to
+// limit images with an id above afterID that don't have them yet, filling in
+// missing dimensions on the way. Walking by id means images that fail keep
+// failing without blocking the rest.
+func BackfillPlaceholders(ctx context.Context, db *pgxpool.Pool, store storage.Storage, afterID, limit int) (*PlaceholderReport, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, s3_key FROM images
+		WHERE blurhash IS NULL AND status <> 'pending' AND id > $1
+		ORDER BY id
+		LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	type pending struct {
+		id  int
+		key string
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &PlaceholderReport{Failed: []BackfillFailure{}, NextAfterID: afterID}
+	for _, p := range batch {
+		report.NextAfterID = p.id
+		if err := backfillPlaceholder(ctx, db, store, p.id, p.key); err != nil {
+			report.Failed = append(report.Failed, BackfillFailure{ID: p.id, Error: err.Error()})
+			continue
+		}
+		report.Processed++
+	}
+
+	err = db.QueryRow(ctx,
+		`SELECT count(*) FROM images WHERE blurhash IS NULL AND status <> 'pending' AND id > $1`,
+		report.NextAfterID,
+	).Scan(&report.Remaining)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func backfillPlaceholder(ctx context.Context, db *pgxpool.Pool, store storage.Storage, id int, key string) error {
+	obj, err := store.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", key, err)
+	}
+	data, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil {
+		return fmt.Errorf("read %s: %w", key, err)
+	}
+	decoded, err := imaging.Inspect(data, imaging.DefaultLimits)
+	if err != nil {
+		return err
+	}
+
+	// Stored files are already upright, but older ones may still carry an
+	// EXIF orientation
+	img := imaging.Orient(decoded.Image, imaging.ExtractMetadata(data).Orientation)
+	placeholder := imaging.NewPlaceholder(img)
+	b := img.Bounds()
+
+	// The key check skips images whose file was replaced in the meantime;
+	// the replacement brought its own placeholder
+	_, err = db.Exec(ctx,
+		`UPDATE images SET
+			blurhash = $1,
+			dominant_color = $2,
+			width = COALESCE(width, $3),
+			height = COALESCE(height, $4)
+		WHERE id = $5 AND s3_key = $6`,
+		placeholder.BlurHash, placeholder.Color, b.Dx(), b.Dy(), id, key)
+	return err
+}
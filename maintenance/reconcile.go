@@ -0,0 +1,238 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"golang-api/storage"
+)
+
+// ImagePrefix is the storage prefix holding originals and their variants.
+const ImagePrefix = "images/"
+
+// referencedKeys lists every query returning object keys the database
+// points at. Objects under ImagePrefix that none of them return are orphans.
+var referencedKeys = []string{
+	`SELECT s3_key FROM images`,
+	`SELECT s3_key FROM image_variants`,
+	`SELECT s3_key FROM image_versions`,
+}
+
+// ReconcileOptions controls Reconcile.
+type ReconcileOptions struct {
+	// Fix deletes orphaned objects and dangling rows instead of only
+	// reporting them.
+	Fix bool
+	// Grace skips objects modified more recently than this, so uploads that
+	// have written their object but not yet committed their row are left alone.
+	Grace time.Duration
+}
+
+// DanglingRow is a database row whose object is missing from storage.
+type DanglingRow struct {
+	Table   string `json:"table"`
+	ID      int    `json:"id"`
+	ImageID int    `json:"image_id"`
+	S3Key   string `json:"s3_key"`
+}
+
+// ReconcileReport is the outcome of Reconcile.
+type ReconcileReport struct {
+	DryRun          bool                 `json:"dry_run"`
+	ObjectsScanned  int                  `json:"objects_scanned"`
+	KeysReferenced  int                  `json:"keys_referenced"`
+	OrphanedObjects []storage.ObjectInfo `json:"orphaned_objects"`
+	DanglingRows    []DanglingRow        `json:"dangling_rows"`
+	Errors          []string             `json:"errors,omitempty"`
+}
+
+// Reconcile compares the objects under ImagePrefix with the keys referenced
+// by the database and reports objects without a row and rows without an
+// object. With opts.Fix set it deletes both; a dangling images row takes its
+// variants and versions (rows and objects) with it.
+func Reconcile(ctx context.Context, db *pgxpool.Pool, store storage.Storage, opts ReconcileOptions) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		DryRun:          !opts.Fix,
+		OrphanedObjects: []storage.ObjectInfo{},
+		DanglingRows:    []DanglingRow{},
+	}
+
+	// The database is read before storage is listed, from one snapshot so
+	// the tables agree with each other: a row committed after this point is
+	// not looked at, and its object is either listed below or is too new to
+	// count as an orphan.
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	referenced := map[string]bool{}
+	for _, q := range referencedKeys {
+		keys, err := queryKeys(ctx, tx, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			referenced[k] = true
+		}
+	}
+	report.KeysReferenced = len(referenced)
+
+	// Pending rows have no object until the client finishes its upload
+	danglingQueries := []struct{ table, sql string }{
+		{"images", `SELECT id, id, s3_key FROM images WHERE status <> 'pending'`},
+		{"image_variants", `SELECT id, image_id, s3_key FROM image_variants`},
+		{"image_versions", `SELECT id, image_id, s3_key FROM image_versions`},
+	}
+	var candidates []DanglingRow
+	for _, dq := range danglingQueries {
+		rows, err := tx.Query(ctx, dq.sql)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			row := DanglingRow{Table: dq.table}
+			if err := rows.Scan(&row.ID, &row.ImageID, &row.S3Key); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			// Only keys under the listed prefix can be told missing
+			if strings.HasPrefix(row.S3Key, ImagePrefix) {
+				candidates = append(candidates, row)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	tx.Rollback(context.Background())
+
+	objects, err := store.List(ctx, ImagePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("list storage: %w", err)
+	}
+	report.ObjectsScanned = len(objects)
+	stored := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		stored[obj.Key] = true
+	}
+
+	cutoff := time.Now().Add(-opts.Grace)
+	for _, obj := range objects {
+		if !referenced[obj.Key] && obj.LastModified.Before(cutoff) {
+			report.OrphanedObjects = append(report.OrphanedObjects, obj)
+		}
+	}
+	for _, row := range candidates {
+		if !stored[row.S3Key] {
+			report.DanglingRows = append(report.DanglingRows, row)
+		}
+	}
+
+	if opts.Fix {
+		fix(ctx, db, store, report)
+	}
+	return report, nil
+}
+
+func fix(ctx context.Context, db *pgxpool.Pool, store storage.Storage, report *ReconcileReport) {
+	for _, obj := range report.OrphanedObjects {
+		// A row may have taken the key since the snapshot was read
+		inUse, err := keyReferenced(ctx, db, obj.Key)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("check object %s: %v", obj.Key, err))
+			continue
+		}
+		if inUse {
+			continue
+		}
+		if err := store.Delete(ctx, obj.Key); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("delete object %s: %v", obj.Key, err))
+		}
+	}
+
+	for _, row := range report.DanglingRows {
+		// The listing may have been incomplete or stale, ask for the key itself
+		if _, err := store.Stat(ctx, row.S3Key); !errors.Is(err, storage.ErrNotFound) {
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("stat object %s: %v", row.S3Key, err))
+			}
+			continue
+		}
+		switch row.Table {
+		case "images":
+			var owned []string
+			var queryErr error
+			for _, q := range imageKeys {
+				keys, err := queryKeys(ctx, db, q, row.ID)
+				if err != nil {
+					queryErr = err
+					break
+				}
+				owned = append(owned, keys...)
+			}
+			if queryErr != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("images %d: %v", row.ID, queryErr))
+				continue
+			}
+			if _, err := db.Exec(ctx, `DELETE FROM images WHERE id = $1`, row.ID); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("delete images %d: %v", row.ID, err))
+				continue
+			}
+			for _, key := range owned {
+				if err := store.Delete(ctx, key); err != nil {
+					report.Errors = append(report.Errors, fmt.Sprintf("delete object %s: %v", key, err))
+				}
+			}
+		case "image_variants", "image_versions":
+			if _, err := db.Exec(ctx, `DELETE FROM `+row.Table+` WHERE id = $1`, row.ID); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("delete %s %d: %v", row.Table, row.ID, err))
+			}
+		}
+	}
+}
+
+// keyReferenced reports whether any of the referencedKeys tables points at key.
+func keyReferenced(ctx context.Context, db *pgxpool.Pool, key string) (bool, error) {
+	for _, q := range referencedKeys {
+		var found bool
+		if err := db.QueryRow(ctx, `SELECT EXISTS (`+q+` WHERE s3_key = $1)`, key).Scan(&found); err != nil {
+			return false, err
+		}
+		if found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// querier is what queryKeys needs from a pool or a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+func queryKeys(ctx context.Context, db querier, sql string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
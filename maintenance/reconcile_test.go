@@ -0,0 +1,87 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"golang-api/storage"
+)
+
+func TestReconcileReport(t *testing.T) {
+	db := testDB(t)
+	store := testStore()
+	ctx := context.Background()
+
+	kept := ImagePrefix + uuid.New().String() + ".png"
+	orphan := ImagePrefix + uuid.New().String() + ".png"
+	missing := ImagePrefix + uuid.New().String() + ".png"
+	putObject(t, store, kept, "kept")
+	putObject(t, store, orphan, "orphan")
+	testImageRow(t, db, kept)
+	danglingID := testImageRow(t, db, missing)
+
+	report, err := Reconcile(ctx, db, store, ReconcileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun {
+		t.Error("DryRun = false without Fix")
+	}
+	if len(report.OrphanedObjects) != 1 || report.OrphanedObjects[0].Key != orphan {
+		t.Errorf("orphans %v, want only %s", report.OrphanedObjects, orphan)
+	}
+	found := false
+	for _, row := range report.DanglingRows {
+		if row.S3Key == kept {
+			t.Errorf("row of stored object %s reported dangling", kept)
+		}
+		if row.Table == "images" && row.ID == danglingID {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("dangling rows %v, want images %d", report.DanglingRows, danglingID)
+	}
+
+	// A dry run changes nothing
+	if _, err := store.Stat(ctx, orphan); err != nil {
+		t.Errorf("dry run removed the orphan: %v", err)
+	}
+}
+
+func TestReconcileFixRechecksOrphans(t *testing.T) {
+	db := testDB(t)
+	store := testStore()
+	ctx := context.Background()
+
+	orphan := ImagePrefix + uuid.New().String() + ".png"
+	claimed := ImagePrefix + uuid.New().String() + ".png"
+	putObject(t, store, orphan, "orphan")
+	putObject(t, store, claimed, "claimed")
+
+	// Both were orphans when the snapshot was read; since then a row took
+	// the second one
+	report := &ReconcileReport{}
+	for _, key := range []string{orphan, claimed} {
+		obj, err := store.Stat(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report.OrphanedObjects = append(report.OrphanedObjects, obj)
+	}
+	testImageRow(t, db, claimed)
+
+	fix(ctx, db, store, report)
+	if len(report.Errors) > 0 {
+		t.Fatalf("errors: %v", report.Errors)
+	}
+	if _, err := store.Stat(ctx, orphan); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("orphan still stored: %v", err)
+	}
+	if _, err := store.Stat(ctx, claimed); err != nil {
+		t.Errorf("object referenced since the snapshot was deleted: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"golang-api/storage"
+)
+
+// ErrNotInTrash is returned by PurgeImage for images that are missing or
+// have not been deleted.
+var ErrNotInTrash = errors.New("image is not in the trash")
+
+// imageKeys lists the queries returning every object key owned by the image
+// with id $1.
+var imageKeys = []string{
+	`SELECT s3_key FROM images WHERE id = $1`,
+	`SELECT s3_key FROM image_variants WHERE image_id = $1`,
+	`SELECT s3_key FROM image_versions WHERE image_id = $1`,
+}
+
+// PurgeImage permanently removes a trashed image: its row (variants follow
+// through ON DELETE CASCADE) and then every object it owned. Objects that
+// fail to delete are left for Reconcile.
+func PurgeImage(ctx context.Context, db *pgxpool.Pool, store storage.Storage, imageID int) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx,
+		`SELECT id FROM images WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`, imageID,
+	).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return ErrNotInTrash
+	}
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	for _, q := range imageKeys {
+		rows, err := tx.Query(ctx, q, imageID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return err
+			}
+			keys = append(keys, key)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM images WHERE id = $1`, imageID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// PurgeTrash purges every image that has been in the trash for longer than
+// retention and returns how many were removed. A failed image doesn't stop
+// the others; the error joins the failure of each.
+func PurgeTrash(ctx context.Context, db *pgxpool.Pool, store storage.Storage, retention time.Duration) (int, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id FROM images WHERE deleted_at < $1 ORDER BY id`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var errs []error
+	for _, id := range ids {
+		err := PurgeImage(ctx, db, store, id)
+		if errors.Is(err, ErrNotInTrash) {
+			// Restored in the meantime
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("image %d: %w", id, err))
+			continue
+		}
+		purged++
+	}
+	return purged, errors.Join(errs...)
+}
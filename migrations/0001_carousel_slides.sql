@@ -0,0 +1,15 @@
+-- Slides shown on the Portfolio page. Every slot points at a row in images;
+-- the side slots are optional.
+CREATE TABLE IF NOT EXISTS carousel_slides (
+    id             SERIAL PRIMARY KEY,
+    main_image_id  INT NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    left_image_id  INT REFERENCES images(id) ON DELETE SET NULL,
+    right_image_id INT REFERENCES images(id) ON DELETE SET NULL,
+    category_id    INT REFERENCES categories(id) ON DELETE SET NULL,
+    description    TEXT NOT NULL DEFAULT '',
+    alt_text       TEXT NOT NULL DEFAULT '',
+    position       INT NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS carousel_slides_position_idx ON carousel_slides (position, id);
@@ -0,0 +1,11 @@
+-- Two-phase uploads: a row starts as 'pending' until the client has PUT the
+-- object and called the complete endpoint. Pending rows past
+-- upload_expires_at are removed by maintenance.ExpirePendingUploads.
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS status            TEXT NOT NULL DEFAULT 'active',
+    ADD COLUMN IF NOT EXISTS upload_expires_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS content_type      TEXT,
+    ADD COLUMN IF NOT EXISTS size_bytes        BIGINT;
+
+CREATE INDEX IF NOT EXISTS images_pending_expiry_idx
+    ON images (upload_expires_at) WHERE status = 'pending';
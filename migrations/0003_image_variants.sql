@@ -0,0 +1,14 @@
+-- Resized derivatives of an image. Every variant exists in the image's base
+-- format (jpeg or png) and as webp.
+CREATE TABLE IF NOT EXISTS image_variants (
+    id         SERIAL PRIMARY KEY,
+    image_id   INT NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    format     TEXT NOT NULL,
+    s3_key     TEXT NOT NULL UNIQUE,
+    width      INT NOT NULL,
+    height     INT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (image_id, name, format)
+);
@@ -0,0 +1,7 @@
+-- EXIF data extracted on upload. The stored objects are stripped, so this is
+-- the only place GPS coordinates and camera details survive; keep it out of
+-- public responses.
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS width    INT,
+    ADD COLUMN IF NOT EXISTS height   INT;
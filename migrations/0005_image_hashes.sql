@@ -0,0 +1,7 @@
+-- Content hash (of the stored, stripped bytes) and 64-bit difference hash
+-- used to detect exact and near-duplicate uploads.
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS sha256 TEXT,
+    ADD COLUMN IF NOT EXISTS phash  BIGINT;
+
+CREATE INDEX IF NOT EXISTS images_sha256_idx ON images (sha256);
@@ -0,0 +1,6 @@
+-- Deleting an image only moves it to the trash; the row and its objects are
+-- purged after the retention period (maintenance.PurgeTrash).
+ALTER TABLE images ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS images_deleted_at_idx
+    ON images (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,19 @@
+-- Superseded files of an image. The current file stays on the images row;
+-- replacing or reverting it archives the current state here first.
+ALTER TABLE images ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS image_versions (
+    id           SERIAL PRIMARY KEY,
+    image_id     INT NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    version      INT NOT NULL,
+    s3_key       TEXT NOT NULL,
+    content_type TEXT,
+    size_bytes   BIGINT,
+    sha256       TEXT,
+    phash        BIGINT,
+    metadata     JSONB NOT NULL DEFAULT '{}',
+    width        INT,
+    height       INT,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (image_id, version)
+);
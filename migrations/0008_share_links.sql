@@ -0,0 +1,16 @@
+-- Public links to a single image or a whole category. Only a hash of the
+-- token is stored; the token itself is shown once, when the link is created.
+CREATE TABLE IF NOT EXISTS share_links (
+    id            SERIAL PRIMARY KEY,
+    token_hash    TEXT NOT NULL UNIQUE,
+    image_id      INT REFERENCES images(id) ON DELETE CASCADE,
+    category_id   INT REFERENCES categories(id) ON DELETE CASCADE,
+    password_hash TEXT,
+    expires_at    TIMESTAMPTZ NOT NULL,
+    max_views     INT,
+    views         INT NOT NULL DEFAULT 0,
+    created_by    TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at    TIMESTAMPTZ,
+    CHECK ((image_id IS NULL) <> (category_id IS NULL))
+);
@@ -0,0 +1,25 @@
+-- Watermarked copies of the derivatives for public and shared delivery.
+-- Originals and the owner's derivatives stay clean.
+CREATE TABLE IF NOT EXISTS watermark_settings (
+    id          INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    enabled     BOOLEAN NOT NULL DEFAULT false,
+    text        TEXT NOT NULL DEFAULT '',
+    overlay_key TEXT,
+    position    TEXT NOT NULL DEFAULT 'bottom-right',
+    opacity     REAL NOT NULL DEFAULT 0.5,
+    scale       REAL NOT NULL DEFAULT 0.25,
+    -- Bumped on every change; images whose watermark_version differs are stale
+    version     INT NOT NULL DEFAULT 1,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO watermark_settings (id) VALUES (1) ON CONFLICT DO NOTHING;
+
+-- Per category switch, and a per image override (NULL follows the category)
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS watermark BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS watermark BOOLEAN;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS watermark_version INT;
+
+ALTER TABLE image_variants ADD COLUMN IF NOT EXISTS watermarked BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE image_variants DROP CONSTRAINT IF EXISTS image_variants_image_id_name_format_key;
+ALTER TABLE image_variants ADD CONSTRAINT image_variants_image_id_name_format_watermarked_key
+    UNIQUE (image_id, name, format, watermarked);
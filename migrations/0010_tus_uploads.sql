@@ -0,0 +1,21 @@
+-- Resumable uploads (tus 1.0). Every PATCH is stored as its own object under
+-- tus/<id>/ and listed in tus_upload_chunks; the chunks are joined when the
+-- last byte arrives and the result goes through the regular image creation.
+CREATE TABLE IF NOT EXISTS tus_uploads (
+    id            TEXT PRIMARY KEY,
+    upload_length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    metadata      JSONB NOT NULL DEFAULT '{}',
+    image_id      INT REFERENCES images(id) ON DELETE SET NULL,
+    created_by    TEXT NOT NULL,
+    expires_at    TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS tus_upload_chunks (
+    upload_id    TEXT NOT NULL REFERENCES tus_uploads(id) ON DELETE CASCADE,
+    chunk_offset BIGINT NOT NULL,
+    s3_key       TEXT NOT NULL,
+    size_bytes   BIGINT NOT NULL,
+    PRIMARY KEY (upload_id, chunk_offset)
+);
@@ -0,0 +1,12 @@
+-- Progress of `porto migrate-storage`. A row means the object was copied to
+-- the destination under dest_key and read back with a matching checksum, so
+-- an interrupted run picks up where it stopped.
+CREATE TABLE IF NOT EXISTS storage_migration_objects (
+    migration   TEXT NOT NULL,
+    s3_key      TEXT NOT NULL,
+    dest_key    TEXT NOT NULL,
+    sha256      TEXT NOT NULL,
+    size_bytes  BIGINT NOT NULL,
+    verified_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (migration, s3_key)
+);
@@ -0,0 +1,6 @@
+-- Placeholders shown while an image loads: a BlurHash (https://blurha.sh)
+-- and the dominant color as #rrggbb. Rows from before this migration are
+-- filled in by POST /api/maintenance/backfill-placeholders or `porto backfill-placeholders`.
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS blurhash       TEXT,
+    ADD COLUMN IF NOT EXISTS dominant_color TEXT;
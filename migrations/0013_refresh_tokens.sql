@@ -0,0 +1,16 @@
+-- Server-side refresh tokens. Every refresh marks the presented token used
+-- and issues a new one in the same family; presenting a used token again
+-- means it was stolen, and the whole family is revoked.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id          SERIAL PRIMARY KEY,
+    token_hash  TEXT NOT NULL UNIQUE,
+    family_id   TEXT NOT NULL,
+    username    TEXT NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    used_at     TIMESTAMPTZ,
+    revoked_at  TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_username_idx ON refresh_tokens (username);
@@ -0,0 +1,16 @@
+-- One row per login. Access tokens carry the session id (the "sid" claim)
+-- and are only accepted while the session is live, so revoking it logs the
+-- device out right away. The refresh token family of a login uses the
+-- session id as its family_id.
+CREATE TABLE IF NOT EXISTS sessions (
+    id           TEXT PRIMARY KEY,
+    username     TEXT NOT NULL,
+    user_agent   TEXT NOT NULL DEFAULT '',
+    ip           TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS sessions_username_idx ON sessions (username) WHERE revoked_at IS NULL;
@@ -0,0 +1,25 @@
+-- Roles: owner (everything, including users and maintenance), editor
+-- (manages content) and viewer (browses the private collection). Existing
+-- accounts start as viewers except the oldest one, which becomes the owner
+-- so someone can still manage users; change it with
+-- `porto set-role <username> owner`.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'viewer'
+        CHECK (role IN ('owner', 'editor', 'viewer'));
+
+DO $$
+DECLARE
+    oldest TEXT;
+BEGIN
+    IF EXISTS (SELECT 1 FROM users WHERE role = 'owner') THEN
+        RETURN;
+    END IF;
+    -- Oldest by created_at or else id, whichever the table has
+    SELECT column_name INTO oldest FROM information_schema.columns
+    WHERE table_name = 'users' AND column_name IN ('created_at', 'id')
+    ORDER BY column_name = 'created_at' DESC
+    LIMIT 1;
+    EXECUTE format(
+        'UPDATE users SET role = ''owner'' WHERE username = (SELECT username FROM users ORDER BY %I LIMIT 1)',
+        COALESCE(oldest, 'username'));
+END $$;
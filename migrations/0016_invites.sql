@@ -0,0 +1,23 @@
+-- Who may register through POST /api/create: anyone (open), holders of an
+-- invite code (invite-only) or nobody (closed). The very first account can
+-- always be created and becomes the owner.
+CREATE TABLE IF NOT EXISTS registration_settings (
+    id         INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    mode       TEXT NOT NULL DEFAULT 'invite-only' CHECK (mode IN ('open', 'invite-only', 'closed')),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO registration_settings (id) VALUES (1) ON CONFLICT DO NOTHING;
+
+-- Invite codes, stored as a SHA-256 of the code. Each registration uses one
+-- of max_uses and gets the invite's role.
+CREATE TABLE IF NOT EXISTS invite_codes (
+    id         SERIAL PRIMARY KEY,
+    code_hash  TEXT NOT NULL UNIQUE,
+    role       TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    max_uses   INT NOT NULL DEFAULT 1 CHECK (max_uses > 0),
+    uses       INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMPTZ
+);
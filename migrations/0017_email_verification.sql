@@ -0,0 +1,12 @@
+-- Accounts can only log in once their email is confirmed. Accounts that
+-- exist when this runs are taken as verified.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'users' AND column_name = 'email_verified_at'
+    ) THEN
+        ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+        UPDATE users SET email_verified_at = now();
+    END IF;
+END $$;
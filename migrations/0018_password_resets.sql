@@ -0,0 +1,12 @@
+-- One-time password reset tokens, stored as a SHA-256 of the token. A token
+-- is spent by its first use and expires after half an hour otherwise.
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id         SERIAL PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    username   TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS password_reset_tokens_username_idx ON password_reset_tokens (username);
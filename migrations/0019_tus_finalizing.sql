@@ -0,0 +1,5 @@
+-- A finished tus upload is turned into an image by whichever request claims
+-- it first; finalizing_at marks the claim. Claims older than a few minutes
+-- are from a request that died and may be taken over.
+ALTER TABLE tus_uploads
+    ADD COLUMN IF NOT EXISTS finalizing_at TIMESTAMPTZ;
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps objects in a map. It is meant for tests and quick local runs;
+// everything is lost when the process exits.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]memObject
+	signer
+}
+
+type memObject struct {
+	data []byte
+	info ObjectInfo
+}
+
+// NewMemory creates an empty Memory backend.
+func NewMemory(baseURL string, key []byte) *Memory {
+	return &Memory{
+		objects: make(map[string]memObject),
+		signer:  signer{baseURL: baseURL, key: key},
+	}
+}
+
+func (m *Memory) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = contentTypeOf(key)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memObject{
+		data: data,
+		info: ObjectInfo{
+			Key:          key,
+			Size:         int64(len(data)),
+			ContentType:  contentType,
+			LastModified: time.Now(),
+		},
+	}
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (*Object, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &Object{
+		ReadCloser: io.NopCloser(bytes.NewReader(obj.data)),
+		ObjectInfo: obj.info,
+	}, nil
+}
+
+func (m *Memory) GetRange(ctx context.Context, key string, offset, length int64) (*Object, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	offset = min(offset, int64(len(obj.data)))
+	data := obj.data[offset : offset+clampRange(int64(len(obj.data)), offset, length)]
+	info := obj.info
+	info.Size = int64(len(data))
+	return &Object{
+		ReadCloser: io.NopCloser(bytes.NewReader(data)),
+		ObjectInfo: info,
+	}, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *Memory) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return obj.info, nil
+}
+
+func (m *Memory) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return m.sign(http.MethodGet, key, expires), nil
+}
+
+func (m *Memory) PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (string, error) {
+	return m.sign(http.MethodPut, key, expires), nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var objects []ObjectInfo
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, obj.info)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
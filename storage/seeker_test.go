@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rangeRecorder remembers the offsets objects were opened at.
+type rangeRecorder struct {
+	*Memory
+	offsets []int64
+}
+
+func (r *rangeRecorder) GetRange(ctx context.Context, key string, offset, length int64) (*Object, error) {
+	r.offsets = append(r.offsets, offset)
+	return r.Memory.GetRange(ctx, key, offset, length)
+}
+
+const seekerData = "0123456789abcdef"
+
+func newSeekerStore(t *testing.T) *rangeRecorder {
+	t.Helper()
+	m := NewMemory("http://localhost/storage", []byte("key"))
+	if err := m.Put(context.Background(), "obj", strings.NewReader(seekerData), int64(len(seekerData)), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	return &rangeRecorder{Memory: m}
+}
+
+func TestReadSeeker(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		pos    int64
+		want   string
+	}{
+		{"start", 0, io.SeekStart, 0, seekerData},
+		{"from start", 5, io.SeekStart, 5, "56789abcdef"},
+		{"from current", 3, io.SeekCurrent, 3, "3456789abcdef"},
+		{"from end", -4, io.SeekEnd, 12, "cdef"},
+		{"at end", 0, io.SeekEnd, 16, ""},
+		{"past end", 100, io.SeekStart, 100, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newSeekerStore(t)
+			r := NewReadSeeker(context.Background(), store, "obj", int64(len(seekerData)))
+			defer r.Close()
+
+			pos, err := r.Seek(tt.offset, tt.whence)
+			if err != nil || pos != tt.pos {
+				t.Fatalf("Seek = %d, %v; want %d", pos, err, tt.pos)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+			// Nothing is opened when there is nothing left to read
+			if tt.want == "" && len(store.offsets) > 0 {
+				t.Errorf("opened at %v past the end", store.offsets)
+			} else if tt.want != "" && (len(store.offsets) != 1 || store.offsets[0] != tt.pos) {
+				t.Errorf("opened at %v, want [%d]", store.offsets, tt.pos)
+			}
+		})
+	}
+}
+
+func TestReadSeekerReopensAfterSeek(t *testing.T) {
+	store := newSeekerStore(t)
+	r := NewReadSeeker(context.Background(), store, "obj", int64(len(seekerData)))
+	defer r.Close()
+
+	buf := make([]byte, 4)
+	io.ReadFull(r, buf)
+	// Seeking to where it already is keeps the open range
+	r.Seek(4, io.SeekStart)
+	io.ReadFull(r, buf)
+	r.Seek(-2, io.SeekCurrent)
+	io.ReadFull(r, buf)
+	if string(buf) != "6789" {
+		t.Errorf("read %q after seeking back, want %q", buf, "6789")
+	}
+	if want := []int64{0, 6}; len(store.offsets) != 2 || store.offsets[0] != want[0] || store.offsets[1] != want[1] {
+		t.Errorf("opened at %v, want %v", store.offsets, want)
+	}
+
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("negative seek accepted")
+	}
+}
+
+func TestReadSeekerServeContent(t *testing.T) {
+	tests := []struct {
+		rangeHeader string
+		status      int
+		want        string
+	}{
+		{"", http.StatusOK, seekerData},
+		{"bytes=2-5", http.StatusPartialContent, "2345"},
+		{"bytes=10-", http.StatusPartialContent, "abcdef"},
+		{"bytes=-3", http.StatusPartialContent, "def"},
+		{"bytes=20-30", http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rangeHeader, func(t *testing.T) {
+			store := newSeekerStore(t)
+			r := NewReadSeeker(context.Background(), store, "obj", int64(len(seekerData)))
+			defer r.Close()
+
+			req := httptest.NewRequest(http.MethodGet, "/obj", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Type", "text/plain")
+			http.ServeContent(w, req, "", time.Time{}, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusRequestedRangeNotSatisfiable && !bytes.Equal(w.Body.Bytes(), []byte(tt.want)) {
+				t.Errorf("body %q, want %q", w.Body, tt.want)
+			}
+		})
+	}
+}
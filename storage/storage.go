@@ -0,0 +1,114 @@
+// Package storage hides the object store that holds image files behind a
+// small interface, so the API can run against S3 (or any S3-compatible
+// provider), a local directory or plain memory.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned when the requested key does not exist.
+var ErrNotFound = errors.New("storage: object not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Object is an open object body together with its metadata. Callers must
+// Close it.
+type Object struct {
+	io.ReadCloser
+	ObjectInfo
+}
+
+// Storage is implemented by every backend.
+type Storage interface {
+	// Put stores body under key. size may be -1 when unknown.
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key.
+	Get(ctx context.Context, key string) (*Object, error)
+	// GetRange opens length bytes of key starting at offset, or everything
+	// from offset on when length is negative. The returned Size is that of
+	// the range.
+	GetRange(ctx context.Context, key string, offset, length int64) (*Object, error)
+	// Stat returns the metadata of key without reading it.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a URL that allows reading key until expires elapses.
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	// PresignPut returns a URL that accepts a single HTTP PUT of key with the
+	// given content type and size until expires elapses.
+	PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (string, error)
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// URLVerifier is implemented by backends that cannot hand out URLs of their
+// own (local and memory). Their presigned URLs point back at the API, which
+// checks the signature before serving the object.
+type URLVerifier interface {
+	VerifyURL(method, key, expires, signature string) error
+}
+
+// DefaultBucket is the bucket used when DIMAS_S3_BUCKET is not set.
+const DefaultBucket = "myport-crunchy-personal"
+
+// FromEnv builds the backend selected by DIMAS_STORAGE_BACKEND ("s3",
+// "local" or "memory"; defaults to "s3").
+//
+//	s3:     DIMAS_AWS_REGION, DIMAS_AWS_ACCESS_KEY_ID, DIMAS_AWS_SECRET_ACCESS_KEY,
+//	        DIMAS_S3_BUCKET and, for other providers, DIMAS_S3_ENDPOINT
+//	local:  DIMAS_STORAGE_DIR, DIMAS_STORAGE_URL
+//	memory: DIMAS_STORAGE_URL
+//
+// DIMAS_STORAGE_URL is the public base of the API route serving stored
+// objects, e.g. http://localhost:3000/api/storage.
+func FromEnv(ctx context.Context) (Storage, error) {
+	return FromLookup(ctx, os.Getenv)
+}
+
+// FromLookup is FromEnv with the variables read through getenv, so a second
+// backend can be configured next to the main one.
+func FromLookup(ctx context.Context, getenv func(string) string) (Storage, error) {
+	switch backend := getenv("DIMAS_STORAGE_BACKEND"); backend {
+	case "", "s3":
+		bucket := getenv("DIMAS_S3_BUCKET")
+		if bucket == "" {
+			bucket = DefaultBucket
+		}
+		return NewS3(ctx, S3Config{
+			Region:          getenv("DIMAS_AWS_REGION"),
+			AccessKeyID:     getenv("DIMAS_AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: getenv("DIMAS_AWS_SECRET_ACCESS_KEY"),
+			Endpoint:        getenv("DIMAS_S3_ENDPOINT"),
+			Bucket:          bucket,
+		})
+	case "local":
+		dir := getenv("DIMAS_STORAGE_DIR")
+		if dir == "" {
+			dir = "./data"
+		}
+		return NewLocal(dir, getenv("DIMAS_STORAGE_URL"), signingKey(getenv))
+	case "memory":
+		return NewMemory(getenv("DIMAS_STORAGE_URL"), signingKey(getenv)), nil
+	default:
+		return nil, fmt.Errorf("storage: unknown backend %q", backend)
+	}
+}
+
+func signingKey(getenv func(string) string) []byte {
+	if key := getenv("DIMAS_STORAGE_SIGNING_KEY"); key != "" {
+		return []byte(key)
+	}
+	return []byte(getenv("DIMAS_JWT_ACCESS_TOKEN"))
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// URLCache hands out presigned GET URLs of a Storage and reuses them until
+// shortly before they expire, so listing the same images over and over
+// doesn't re-sign every key on every request.
+type URLCache struct {
+	store Storage
+	// margin is how long before its expiry a cached URL stops being handed
+	// out, leaving the client time to actually use it.
+	margin  time.Duration
+	workers int
+
+	mu        sync.Mutex
+	entries   map[urlCacheKey]cachedURL
+	nextSweep time.Time
+}
+
+type urlCacheKey struct {
+	key     string
+	expires time.Duration
+}
+
+type cachedURL struct {
+	url        string
+	validUntil time.Time
+}
+
+// NewURLCache wraps store. URLs are reused until margin before their expiry;
+// PresignGetMany signs uncached keys with up to workers goroutines.
+func NewURLCache(store Storage, margin time.Duration, workers int) *URLCache {
+	if workers < 1 {
+		workers = 1
+	}
+	return &URLCache{
+		store:   store,
+		margin:  margin,
+		workers: workers,
+		entries: map[urlCacheKey]cachedURL{},
+	}
+}
+
+// PresignGet returns a URL for key that stays valid for at least margin, out
+// of the cache when possible.
+func (c *URLCache) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if url, ok := c.lookup(key, expires, time.Now()); ok {
+		return url, nil
+	}
+	return c.sign(ctx, key, expires)
+}
+
+// PresignGetMany returns a URL for every key in keys. Keys missing from the
+// cache are signed in parallel; the first signing error is returned.
+func (c *URLCache) PresignGetMany(ctx context.Context, keys []string, expires time.Duration) (map[string]string, error) {
+	urls := make(map[string]string, len(keys))
+	var missing []string
+
+	now := time.Now()
+	c.mu.Lock()
+	for _, key := range keys {
+		if _, seen := urls[key]; seen {
+			continue
+		}
+		if e, ok := c.entries[urlCacheKey{key, expires}]; ok && now.Before(e.validUntil) {
+			urls[key] = e.url
+		} else {
+			urls[key] = ""
+			missing = append(missing, key)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(missing) == 0 {
+		return urls, nil
+	}
+
+	signed := make([]string, len(missing))
+	errs := make([]error, len(missing))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(c.workers, len(missing)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				signed[i], errs[i] = c.sign(ctx, missing[i], expires)
+			}
+		}()
+	}
+	for i := range missing {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i, key := range missing {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		urls[key] = signed[i]
+	}
+	return urls, nil
+}
+
+func (c *URLCache) lookup(key string, expires time.Duration, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[urlCacheKey{key, expires}]
+	if !ok || !now.Before(e.validUntil) {
+		return "", false
+	}
+	return e.url, true
+}
+
+func (c *URLCache) sign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	signedAt := time.Now()
+	url, err := c.store.PresignGet(ctx, key, expires)
+	if err != nil {
+		return "", err
+	}
+	// URLs that would be stale on arrival aren't worth keeping
+	if expires <= c.margin {
+		return url, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[urlCacheKey{key, expires}] = cachedURL{url: url, validUntil: signedAt.Add(expires - c.margin)}
+	if signedAt.After(c.nextSweep) {
+		for k, e := range c.entries {
+			if !signedAt.Before(e.validUntil) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = signedAt.Add(max(c.margin, time.Minute))
+	}
+	return url, nil
+}
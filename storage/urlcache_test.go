@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// signCounter counts the URLs the backend was asked to sign.
+type signCounter struct {
+	*Memory
+	mu    sync.Mutex
+	signs map[string]int
+}
+
+func (s *signCounter) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	s.mu.Lock()
+	s.signs[key]++
+	s.mu.Unlock()
+	return s.Memory.PresignGet(ctx, key, expires)
+}
+
+func newSignCounter() *signCounter {
+	return &signCounter{Memory: NewMemory("http://localhost/storage", []byte("key")), signs: map[string]int{}}
+}
+
+func TestURLCacheReuses(t *testing.T) {
+	store := newSignCounter()
+	c := NewURLCache(store, 2*time.Minute, 4)
+	ctx := context.Background()
+
+	first, err := c.PresignGet(ctx, "a", 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := c.PresignGetMany(ctx, []string{"a", "b", "c", "b"}, 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls["a"] != first {
+		t.Errorf("cached URL not reused: %q, then %q", first, urls["a"])
+	}
+	if len(urls) != 3 || urls["b"] == "" || urls["c"] == "" {
+		t.Errorf("urls = %v", urls)
+	}
+	for key, n := range store.signs {
+		if n != 1 {
+			t.Errorf("%s signed %d times", key, n)
+		}
+	}
+
+	// Another expiry is another URL
+	c.PresignGet(ctx, "a", time.Hour)
+	if store.signs["a"] != 2 {
+		t.Errorf("a signed %d times, want 2", store.signs["a"])
+	}
+}
+
+func TestURLCacheRefreshesInsideMargin(t *testing.T) {
+	store := newSignCounter()
+	c := NewURLCache(store, 2*time.Minute, 1)
+	ctx := context.Background()
+
+	signedAt := time.Now()
+	c.PresignGet(ctx, "a", 15*time.Minute)
+	e := c.entries[urlCacheKey{"a", 15 * time.Minute}]
+	if until := e.validUntil.Sub(signedAt); until < 13*time.Minute-time.Second || until > 13*time.Minute+time.Second {
+		t.Fatalf("cached for %v, want 13m (expiry less margin)", until)
+	}
+
+	// Still outside the margin: reused
+	c.PresignGet(ctx, "a", 15*time.Minute)
+	if store.signs["a"] != 1 {
+		t.Fatalf("a signed %d times before the margin", store.signs["a"])
+	}
+
+	// Move into the margin: less than 2 minutes of validity left
+	e.validUntil = time.Now()
+	c.entries[urlCacheKey{"a", 15 * time.Minute}] = e
+	c.PresignGet(ctx, "a", 15*time.Minute)
+	c.PresignGetMany(ctx, []string{"a"}, 15*time.Minute)
+	if store.signs["a"] != 2 {
+		t.Errorf("a signed %d times, want 2 (one refresh)", store.signs["a"])
+	}
+}
+
+func TestURLCacheSkipsShortExpiry(t *testing.T) {
+	store := newSignCounter()
+	c := NewURLCache(store, 2*time.Minute, 1)
+	ctx := context.Background()
+
+	// Would be inside the margin the moment it is signed
+	c.PresignGet(ctx, "a", time.Minute)
+	c.PresignGet(ctx, "a", time.Minute)
+	if store.signs["a"] != 2 {
+		t.Errorf("a signed %d times, want 2", store.signs["a"])
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("%d entries cached", len(c.entries))
+	}
+}